@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/amirgamil/lispy/pkg/lispy"
+)
+
+const checkUsage = `Usage: lispy check path ...
+
+Reports likely mistakes in .lpy files without running them, directories are searched for .lpy files.
+Exits with status 1 if anything is reported.
+`
+
+//runs lispy check with the arguments after check, returning the exit code
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, checkUsage)
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	files, err := lpyFiles(flags.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status := 0
+	for _, file := range files {
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		diagnostics, err := lispy.Check(string(source))
+		if err != nil {
+			if parseErr, isParseErr := err.(*lispy.ParseError); isParseErr {
+				parseErr.File = file
+			}
+			fmt.Fprintln(os.Stderr, "Error parsing", err)
+			status = 1
+			continue
+		}
+		for _, diagnostic := range diagnostics {
+			diagnostic.File = file
+			fmt.Println(diagnostic)
+			status = 1
+		}
+	}
+	return status
+}
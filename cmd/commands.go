@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amirgamil/lispy/pkg/lispy"
+)
+
+//replSession is the state of an interactive session that meta-commands can look at or change
+type replSession struct {
+	env *lispy.Env
+	//creates a fresh environment for :reset
+	newEnv func() *lispy.Env
+	quit   bool
+}
+
+//meta-commands start with a colon and are handled by the repl itself instead of being evaluated
+type command struct {
+	usage string
+	help  string
+	run   func(session *replSession, arg string)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		":help":   {":help", "show this message", showHelp},
+		":env":    {":env [filter]", "list everything defined, or only names containing filter", showEnv},
+		":doc":    {":doc sym", "show the parameters of a function or the type of a value", showDoc},
+		":source": {":source sym", "show the code which defined sym", showSource},
+		":type":   {":type expr", "evaluate expr and show the type of the result", showType},
+		":time":   {":time expr", "evaluate expr and show how long it took", timeExpr},
+		":expand": {":expand expr", "show what the macros in expr expand to", showExpansion},
+		":load":   {":load file", "evaluate a file in the current session", loadFile},
+		":reset":  {":reset", "throw away every definition and start a fresh environment", resetSession},
+		":quit":   {":quit", "leave the repl", quitSession},
+	}
+}
+
+//runs a line starting with : as a meta-command
+func runCommand(session *replSession, line string) {
+	line = strings.TrimSpace(line)
+	name, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	cmd, found := commands[name]
+	if !found {
+		fmt.Fprintln(os.Stderr, "Unknown command", name+", try :help")
+		return
+	}
+	cmd.run(session, arg)
+}
+
+//names of the meta-commands in sorted order, used for :help and completion
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func showHelp(session *replSession, arg string) {
+	for _, name := range commandNames() {
+		fmt.Printf("  %-16s %s\n", commands[name].usage, commands[name].help)
+	}
+}
+
+func showEnv(session *replSession, arg string) {
+	names := make([]string, 0)
+	for _, name := range session.env.Bindings() {
+		if strings.Contains(name, arg) {
+			names = append(names, name)
+		}
+	}
+	printColumns(names, 80)
+}
+
+//prints words separated by two spaces, wrapping before width
+func printColumns(words []string, width int) {
+	line := ""
+	for _, word := range words {
+		if line != "" && len(line)+2+len(word) > width {
+			fmt.Println(line)
+			line = ""
+		}
+		if line != "" {
+			line += "  "
+		}
+		line += word
+	}
+	if line != "" {
+		fmt.Println(line)
+	}
+}
+
+//helper for the commands which need an argument
+func requireArg(arg string, usage string) bool {
+	if arg == "" {
+		fmt.Fprintln(os.Stderr, "Usage:", usage)
+		return false
+	}
+	return true
+}
+
+//prints the result of a command or the error it failed with
+func report(res string, err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Println(res)
+}
+
+func showDoc(session *replSession, arg string) {
+	if requireArg(arg, commands[":doc"].usage) {
+		report(session.env.Doc(arg))
+	}
+}
+
+func showSource(session *replSession, arg string) {
+	if requireArg(arg, commands[":source"].usage) {
+		report(session.env.Source(arg))
+	}
+}
+
+func showType(session *replSession, arg string) {
+	if requireArg(arg, commands[":type"].usage) {
+		report(session.env.Type(arg))
+	}
+}
+
+func timeExpr(session *replSession, arg string) {
+	if !requireArg(arg, commands[":time"].usage) {
+		return
+	}
+	start := time.Now()
+	replInput(arg, session.env)
+	fmt.Println("Elapsed time:", time.Since(start))
+}
+
+func showExpansion(session *replSession, arg string) {
+	if !requireArg(arg, commands[":expand"].usage) {
+		return
+	}
+	res, err := session.env.Expand(arg)
+	report(strings.Join(res, "\n"), err)
+}
+
+func loadFile(session *replSession, arg string) {
+	if !requireArg(arg, commands[":load"].usage) {
+		return
+	}
+	source, err := ioutil.ReadFile(arg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading", arg+":", err)
+		return
+	}
+	exprs, err := lispy.Parse(lispy.Read(strings.NewReader(string(source))))
+	if err != nil {
+		if parseErr, isParseErr := err.(*lispy.ParseError); isParseErr {
+			parseErr.File = arg
+		}
+		fmt.Fprintln(os.Stderr, "Error parsing", err)
+		return
+	}
+	if _, err := session.env.TryEval(exprs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Println("Loaded", arg)
+}
+
+func resetSession(session *replSession, arg string) {
+	session.env = session.newEnv()
+	fmt.Println("Started a fresh environment")
+}
+
+func quitSession(session *replSession, arg string) {
+	session.quit = true
+}
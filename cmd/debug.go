@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/amirgamil/lispy/pkg/lispy"
+)
+
+const debugUsage = `Usage: lispy debug [-b breakpoint]... file
+
+Runs file in the debugger, pausing at the first function call or, if breakpoints are given, the first one reached.
+Breakpoints are function names or file:line, and a (break) in the program pauses it too.
+Type help while paused for the commands.
+`
+
+//flag which can be given more than once
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+//runs lispy debug with the arguments after debug, returning the exit code
+func runDebug(args []string, initState func(dir string) *lispy.Env) int {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	var breakpoints stringList
+	flags.Var(&breakpoints, "b", "Pause when this function (or file:line) is called, can be repeated")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, debugUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	file := flags.Arg(0)
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	exprs, err := lispy.Parse(lispy.Read(strings.NewReader(string(source))))
+	if err != nil {
+		if parseErr, isParseErr := err.(*lispy.ParseError); isParseErr {
+			parseErr.File = file
+		}
+		fmt.Fprintln(os.Stderr, "Error parsing", err)
+		return 1
+	}
+	//the program and the debugger read from the same input, so share one reader between them
+	reader := bufio.NewReader(os.Stdin)
+	env := initState(filepath.Dir(file))
+	env.SetInput(reader)
+	debugger := lispy.NewDebugger(reader, os.Stdout)
+	for _, spec := range breakpoints {
+		if err := debugger.Break(spec); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if len(breakpoints) == 0 {
+		debugger.Pause()
+	}
+	env.SetDebugger(debugger)
+	res, err := env.TryEval(lispy.WithFile(exprs, file))
+	print(res)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/amirgamil/lispy/lib"
+	"github.com/amirgamil/lispy/pkg/lispy"
+)
+
+const docUsage = `Usage: lispy doc [-html] [-o file] module ...
+
+Writes a reference page for each module, a path to a .lpy file, a standard library module e.g. lispy.string,
+or builtins for the native builtins. Pages are Markdown unless -html is given.
+`
+
+//a module's reference page
+type docPage struct {
+	Title   string
+	Entries []lispy.DocEntry
+}
+
+var htmlDocTemplate = template.Must(template.New("doc").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Lispy reference</title>
+</head>
+<body>
+{{range .}}<h1>{{.Title}}</h1>
+{{range .Entries}}<h2 id="{{.Name}}"><code>{{.Signature}}</code></h2>
+<p><em>{{.Kind}}</em></p>
+{{if .Doc}}<pre>{{.Doc}}</pre>
+{{end}}{{end}}{{end}}</body>
+</html>
+`))
+
+//runs lispy doc with the arguments after doc, returning the exit code
+func runDoc(args []string) int {
+	flags := flag.NewFlagSet("doc", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, docUsage)
+	}
+	asHTML := flags.Bool("html", false, "Write HTML instead of Markdown")
+	outFile := flags.String("o", "", "Write to a file instead of stdout")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	pages := make([]docPage, 0)
+	for _, module := range flags.Args() {
+		entries, err := moduleDocs(module)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		pages = append(pages, docPage{Title: module, Entries: entries})
+	}
+	var out bytes.Buffer
+	if *asHTML {
+		if err := htmlDocTemplate.Execute(&out, pages); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else {
+		writeMarkdownDocs(&out, pages)
+	}
+	if *outFile == "" {
+		os.Stdout.Write(out.Bytes())
+		return 0
+	}
+	if err := ioutil.WriteFile(*outFile, out.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+//finds the source of a module given to lispy doc and documents it
+func moduleDocs(module string) ([]lispy.DocEntry, error) {
+	if module == "builtins" {
+		return lispy.BuiltinDocs(), nil
+	}
+	var source []byte
+	var err error
+	if strings.HasPrefix(module, "lispy.") && !strings.HasSuffix(module, ".lpy") {
+		file := path.Join(strings.Split(strings.TrimPrefix(module, "lispy."), ".")...) + ".lpy"
+		source, err = fs.ReadFile(lib.FS, file)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a standard library module", module)
+		}
+	} else if source, err = ioutil.ReadFile(module); err != nil {
+		return nil, err
+	}
+	entries, err := lispy.ModuleDocs(string(source))
+	if parseErr, isParseErr := err.(*lispy.ParseError); isParseErr {
+		parseErr.File = module
+	}
+	return entries, err
+}
+
+func writeMarkdownDocs(out *bytes.Buffer, pages []docPage) {
+	for i, page := range pages {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		fmt.Fprintf(out, "# %s\n", page.Title)
+		for _, entry := range page.Entries {
+			fmt.Fprintf(out, "\n## `%s`\n\n*%s*\n", entry.Signature, entry.Kind)
+			if entry.Doc != "" {
+				fmt.Fprintf(out, "\n%s\n", entry.Doc)
+			}
+		}
+	}
+}
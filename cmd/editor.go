@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+//only the most recent lines are kept in the history file
+const maxHistory = 1000
+
+//returned by readLine when ctrl-c is pressed, which discards whatever has been typed so far
+var errInterrupted = errors.New("interrupted")
+
+//lineEditor reads lines from a terminal with readline-style editing, history and tab completion
+type lineEditor struct {
+	fd  int
+	in  *bufio.Reader
+	out io.Writer
+	//lines entered in this and previous sessions, oldest first
+	history     []string
+	historyFile string
+	//returns the candidates for completing a symbol starting with prefix
+	complete func(prefix string) []string
+}
+
+func newLineEditor(in *bufio.Reader, out io.Writer, historyFile string, complete func(prefix string) []string) *lineEditor {
+	e := &lineEditor{fd: int(os.Stdin.Fd()), in: in, out: out, historyFile: historyFile, complete: complete}
+	e.loadHistory()
+	return e
+}
+
+//default location of the history file, empty if there is no home directory to put it in
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".lispy_history")
+}
+
+func (e *lineEditor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	file, err := os.Open(e.historyFile)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		e.history = append(e.history, scanner.Text())
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+		//rewrite the file so it doesn't grow forever
+		if file, err := os.Create(e.historyFile); err == nil {
+			fmt.Fprintln(file, strings.Join(e.history, "\n"))
+			file.Close()
+		}
+	}
+}
+
+//adds a line to the history and appends it to the history file, blank lines and repeats of the last line are skipped
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if e.historyFile == "" {
+		return
+	}
+	file, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
+
+//readLine shows prompt and reads a line (without the trailing newline), returning io.EOF on ctrl-d at an empty line
+//if the input isn't a terminal, lines are read as they are without any editing
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	fmt.Fprint(e.out, prompt)
+	if !isTerminal(e.fd) {
+		return e.readPlainLine()
+	}
+	state, err := makeRaw(e.fd)
+	if err != nil {
+		return e.readPlainLine()
+	}
+	defer restore(e.fd, state)
+	line, err := e.edit(prompt)
+	//raw mode doesn't translate newlines, so move to the start of the next line ourselves
+	fmt.Fprint(e.out, "\r\n")
+	if err == nil {
+		e.addHistory(line)
+	}
+	return line, err
+}
+
+//reads a line as it is, when the input is piped in or the terminal can't be put into raw mode
+func (e *lineEditor) readPlainLine() (string, error) {
+	line, err := e.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		//last line without a newline
+		return line, nil
+	}
+	return strings.TrimSuffix(line, "\n"), err
+}
+
+//buffer being edited along with the position of the cursor in it
+type editState struct {
+	prompt string
+	buf    []rune
+	pos    int
+}
+
+//redraws the whole line and puts the cursor back where it belongs
+func (e *lineEditor) refresh(s *editState) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", s.prompt, string(s.buf))
+	if back := len(s.buf) - s.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func (s *editState) insert(r ...rune) {
+	rest := append(r, s.buf[s.pos:]...)
+	s.buf = append(s.buf[:s.pos], rest...)
+	s.pos += len(r)
+}
+
+//deletes the characters between from and to
+func (s *editState) delete(from int, to int) {
+	s.buf = append(s.buf[:from], s.buf[to:]...)
+	s.pos = from
+}
+
+func (s *editState) set(line string) {
+	s.buf = []rune(line)
+	s.pos = len(s.buf)
+}
+
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+func (e *lineEditor) edit(prompt string) (string, error) {
+	s := &editState{prompt: prompt, buf: make([]rune, 0)}
+	//index into the history of the line being shown, len(history) is the line being typed
+	historyPos := len(e.history)
+	typed := ""
+	showHistory := func(pos int) {
+		if pos < 0 || pos > len(e.history) {
+			return
+		}
+		if historyPos == len(e.history) {
+			typed = string(s.buf)
+		}
+		historyPos = pos
+		if pos == len(e.history) {
+			s.set(typed)
+		} else {
+			s.set(e.history[pos])
+		}
+	}
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case keyEnter, '\n':
+			return string(s.buf), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(s.buf) == 0 {
+				return "", io.EOF
+			}
+			if s.pos < len(s.buf) {
+				s.delete(s.pos, s.pos+1)
+			}
+		case keyBackspace, keyCtrlH:
+			if s.pos > 0 {
+				s.delete(s.pos-1, s.pos)
+			}
+		case keyCtrlA:
+			s.pos = 0
+		case keyCtrlE:
+			s.pos = len(s.buf)
+		case keyCtrlB:
+			if s.pos > 0 {
+				s.pos--
+			}
+		case keyCtrlF:
+			if s.pos < len(s.buf) {
+				s.pos++
+			}
+		case keyCtrlK:
+			s.buf = s.buf[:s.pos]
+		case keyCtrlU:
+			s.delete(0, s.pos)
+		case keyCtrlW:
+			start := s.pos
+			for start > 0 && unicode.IsSpace(s.buf[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(s.buf[start-1]) {
+				start--
+			}
+			s.delete(start, s.pos)
+		case keyCtrlL:
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case keyCtrlP:
+			showHistory(historyPos - 1)
+		case keyCtrlN:
+			showHistory(historyPos + 1)
+		case keyTab:
+			e.completeSymbol(s)
+		case keyEscape:
+			switch e.readEscape() {
+			case "[A", "OA":
+				showHistory(historyPos - 1)
+			case "[B", "OB":
+				showHistory(historyPos + 1)
+			case "[C", "OC":
+				if s.pos < len(s.buf) {
+					s.pos++
+				}
+			case "[D", "OD":
+				if s.pos > 0 {
+					s.pos--
+				}
+			case "[H", "OH", "[1~", "[7~":
+				s.pos = 0
+			case "[F", "OF", "[4~", "[8~":
+				s.pos = len(s.buf)
+			case "[3~":
+				if s.pos < len(s.buf) {
+					s.delete(s.pos, s.pos+1)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				s.insert(r)
+			}
+		}
+		e.refresh(s)
+	}
+}
+
+//reads the rest of an escape sequence e.g. [A for the up arrow
+func (e *lineEditor) readEscape() string {
+	first, _, err := e.in.ReadRune()
+	if err != nil || (first != '[' && first != 'O') {
+		return ""
+	}
+	seq := string(first)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+		seq += string(r)
+		//sequences end with a letter or ~, anything in between is a number or ;
+		if unicode.IsLetter(r) || r == '~' {
+			return seq
+		}
+	}
+}
+
+//characters which can't be part of a symbol, so completion only looks at the symbol under the cursor
+func isDelimiter(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune("()[]'\"@;", r)
+}
+
+//completes the symbol before the cursor as far as possible, listing the candidates if there is more than one
+func (e *lineEditor) completeSymbol(s *editState) {
+	start := s.pos
+	for start > 0 && !isDelimiter(s.buf[start-1]) {
+		start--
+	}
+	prefix := string(s.buf[start:s.pos])
+	if prefix == "" {
+		return
+	}
+	candidates := e.complete(prefix)
+	if len(candidates) == 0 {
+		return
+	}
+	common := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(candidates) == 1 {
+		s.insert([]rune(strings.TrimPrefix(common, prefix) + " ")...)
+	} else if len(common) > len(prefix) {
+		s.insert([]rune(strings.TrimPrefix(common, prefix))...)
+	} else {
+		//nothing more to fill in, so show the options below the line being edited
+		fmt.Fprint(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+	}
+}
+
+//completes against the names bound in an environment, which are already sorted
+func completeFrom(bindings func() []string) func(prefix string) []string {
+	return func(prefix string) []string {
+		candidates := make([]string, 0)
+		for _, name := range bindings() {
+			if strings.HasPrefix(name, prefix) {
+				candidates = append(candidates, name)
+			}
+		}
+		return candidates
+	}
+}
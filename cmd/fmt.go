@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/amirgamil/lispy/pkg/lispy"
+)
+
+const fmtUsage = `Usage: lispy fmt [-check] [path ...]
+
+Reformats .lpy files in place in the canonical style, directories are searched for .lpy files.
+With no paths, formats standard input to standard output.
+`
+
+//runs lispy fmt with the arguments after fmt, returning the exit code
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	check := flags.Bool("check", false, "Don't write anything, list the files which aren't formatted and exit with status 1 if there are any")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, fmtUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		source, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading standard input:", err)
+			return 1
+		}
+		formatted, err := lispy.Format(string(source))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing", err)
+			return 1
+		}
+		if *check {
+			if formatted != string(source) {
+				fmt.Println("<standard input>")
+				return 1
+			}
+			return 0
+		}
+		fmt.Print(formatted)
+		return 0
+	}
+	files, err := lpyFiles(flags.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status := 0
+	for _, file := range files {
+		changed, err := formatFile(file, !*check)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+		} else if changed && *check {
+			fmt.Println(file)
+			status = 1
+		}
+	}
+	return status
+}
+
+//expands directories in paths into the .lpy files inside them
+func lpyFiles(paths []string) ([]string, error) {
+	files := make([]string, 0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && strings.HasSuffix(file, ".lpy") {
+				files = append(files, file)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+//formats a file, returning whether it wasn't already formatted, only writing the result back if write is set
+func formatFile(file string, write bool) (bool, error) {
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	formatted, err := lispy.Format(string(source))
+	if err != nil {
+		if parseErr, isParseErr := err.(*lispy.ParseError); isParseErr {
+			parseErr.File = file
+		}
+		return false, fmt.Errorf("Error parsing %v", err)
+	}
+	if formatted == string(source) {
+		return false, nil
+	}
+	if write {
+		info, err := os.Stat(file)
+		if err != nil {
+			return true, err
+		}
+		return true, ioutil.WriteFile(file, []byte(formatted), info.Mode())
+	}
+	return true, nil
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/amirgamil/lispy/pkg/lispy"
@@ -20,7 +21,7 @@ func read(reader io.Reader) []lispy.Sexp {
 	tokens := lispy.Read(reader)
 	exprs, err := lispy.Parse(tokens)
 	if err != nil {
-		log.Fatal("Error parsing")
+		log.Fatal("Error parsing ", err)
 	}
 	return exprs
 }
@@ -42,37 +43,121 @@ func repl(str io.Reader, env *lispy.Env) {
 	print(eval(read(str), env))
 }
 
-const cliVersion = "0.1.0"
+// evaluates one complete input typed at the repl, reporting errors instead of exiting so the session carries on
+func replInput(source string, env *lispy.Env) {
+	exprs, err := lispy.Parse(lispy.Read(strings.NewReader(source)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing", err)
+		return
+	}
+	res, err := env.TryEval(exprs)
+	print(res)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
 const helpMessage = `
 Welcome to Lispy! Hack away
+
+Usage:
+  lispy [flags] [file]     run a file, or start a repl if no file is given
+  lispy fmt [-check] path  format .lpy files
+  lispy check path         report likely mistakes in .lpy files without running them
+  lispy test [flags] path  run the tests defined with deftest in *_test.lpy files
+  lispy debug [flags] file run a file in the debugger
+  lispy profile [flags] file
+                           run a file, reporting the time spent in each function
+  lispy lsp                run a language server for editors over stdin and stdout
+  lispy doc [flags] module write Markdown or HTML reference pages for modules
+
 `
 
 func main() {
 
 	flag.Usage = func() {
-		fmt.Printf(helpMessage, cliVersion)
+		fmt.Print(helpMessage)
 		flag.PrintDefaults()
 	}
 
 	isRepl := flag.Bool("repl", false, "Run as an interactive repl")
+	modulePath := flag.String("path", "", "List of directories to search for modules (separated by "+string(os.PathListSeparator)+")")
+	traceAll := flag.Bool("trace", false, "Log every function call and macro expansion to stderr")
 	flag.Parse()
 	args := flag.Args()
+	if len(args) > 0 && args[0] == "fmt" {
+		os.Exit(runFmt(args[1:]))
+	} else if len(args) > 0 && args[0] == "check" {
+		os.Exit(runCheck(args[1:]))
+	} else if len(args) > 0 && args[0] == "lsp" {
+		os.Exit(runLsp(args[1:]))
+	} else if len(args) > 0 && args[0] == "doc" {
+		os.Exit(runDoc(args[1:]))
+	}
+	//set up the module path for an environment, a file can always require modules next to it
+	initState := func(dir string) *lispy.Env {
+		env := lispy.InitState()
+		if *modulePath != "" {
+			env.SetModulePath(filepath.SplitList(*modulePath)...)
+		}
+		env.AddModulePath(dir)
+		if *traceAll {
+			env.Trace()
+		}
+		return env
+	}
+	if len(args) > 0 && args[0] == "test" {
+		os.Exit(runTest(args[1:], initState))
+	} else if len(args) > 0 && args[0] == "debug" {
+		os.Exit(runDebug(args[1:], initState))
+	} else if len(args) > 0 && args[0] == "profile" {
+		os.Exit(runProfile(args[1:], initState))
+	}
 	//default to repl if no files given
 	if *isRepl || len(args) == 0 {
 		// repl loop
 		reader := bufio.NewReader(os.Stdin)
-		env := lispy.InitState()
-		for {
-			fmt.Print(Green + "lispy> " + Reset)
-			// reads user input until \n by default
-			text, err := reader.ReadString('\n')
-			if err != nil {
-				log.Fatal("Error reading input from the console")
+		newEnv := func() *lispy.Env {
+			env := initState(".")
+			// share the reader so builtins like readline see anything typed ahead of them
+			env.SetInput(reader)
+			return env
+		}
+		session := &replSession{env: newEnv(), newEnv: newEnv}
+		// completes meta-commands as well as whatever the session has defined (which changes on :reset)
+		bindings := func() []string {
+			return append(commandNames(), session.env.Bindings()...)
+		}
+		editor := newLineEditor(reader, os.Stdout, defaultHistoryFile(), completeFrom(bindings))
+		input := ""
+		for !session.quit {
+			prompt := Green + "lispy> " + Reset
+			if input != "" {
+				// secondary prompt while an expression is still open
+				prompt = Green + "   ... " + Reset
+			}
+			text, err := editor.readLine(prompt)
+			if err == errInterrupted {
+				// ctrl-c throws away the expression being typed
+				input = ""
+				continue
 			} else if err == io.EOF {
+				fmt.Println()
 				break
+			} else if err != nil {
+				log.Fatal("Error reading input from the console")
 			}
-			repl(strings.NewReader(text), env)
-
+			input += text + "\n"
+			// keep reading lines until parentheses, brackets and strings are balanced
+			if !lispy.IsComplete(input) {
+				continue
+			}
+			if strings.HasPrefix(strings.TrimSpace(input), ":") {
+				runCommand(session, input)
+			} else if strings.TrimSpace(input) != "" {
+				replInput(input, session.env)
+			}
+			input = ""
 		}
 	} else {
 		filePath := args[0]
@@ -81,7 +166,7 @@ func main() {
 			log.Fatal("Error opening file to read!")
 		}
 		defer file.Close()
-		env := lispy.InitState()
+		env := initState(filepath.Dir(filePath))
 		repl(file, env)
 	}
 }
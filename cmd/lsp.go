@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/amirgamil/lispy/pkg/lsp"
+)
+
+const lspUsage = `Usage: lispy lsp
+
+Runs a Language Server Protocol server over stdin and stdout, for editors to start when opening .lpy files.
+`
+
+//runs lispy lsp with the arguments after lsp, returning the exit code
+func runLsp(args []string) int {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, lspUsage)
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
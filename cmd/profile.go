@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/amirgamil/lispy/pkg/lispy"
+)
+
+const profileUsage = `Usage: lispy profile [-o file] [-interval duration] file
+
+Runs file while sampling which Lispy functions are running, then prints how much time was spent in each
+(and how many times it was called) to stderr, and writes a pprof profile which go tool pprof can read.
+`
+
+//runs lispy profile with the arguments after profile, returning the exit code
+func runProfile(args []string, initState func(dir string) *lispy.Env) int {
+	flags := flag.NewFlagSet("profile", flag.ExitOnError)
+	output := flags.String("o", "lispy.pprof", "Where to write the pprof profile, or nothing to skip it")
+	interval := flags.Duration("interval", time.Millisecond, "How often to sample")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, profileUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *interval <= 0 {
+		flags.Usage()
+		return 2
+	}
+	file := flags.Arg(0)
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	exprs, err := lispy.Parse(lispy.Read(strings.NewReader(string(source))))
+	if err != nil {
+		if parseErr, isParseErr := err.(*lispy.ParseError); isParseErr {
+			parseErr.File = file
+		}
+		fmt.Fprintln(os.Stderr, "Error parsing", err)
+		return 1
+	}
+	env := initState(filepath.Dir(file))
+	profiler := lispy.NewProfiler(*interval)
+	env.SetProfiler(profiler)
+	profiler.Start()
+	res, evalErr := env.TryEval(lispy.WithFile(exprs, file))
+	profiler.Stop()
+	print(res)
+	if evalErr != nil {
+		fmt.Fprintln(os.Stderr, evalErr)
+	}
+	fmt.Fprintln(os.Stderr)
+	if err := profiler.WriteReport(os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *output != "" {
+		if err := writeProfile(*output, profiler); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing profile:", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "\nWrote %s, explore it with go tool pprof -http=: %s\n", *output, *output)
+	}
+	if evalErr != nil {
+		return 1
+	}
+	return 0
+}
+
+func writeProfile(path string, profiler *lispy.Profiler) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := profiler.WriteProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/amirgamil/lispy/pkg/lispy"
+)
+
+const testUsage = `Usage: lispy test [-v] [-run regexp] [-junit file] [path ...]
+
+Runs the tests defined with deftest in each file, every file gets a fresh environment.
+Directories (by default the current one) are searched for files ending in _test.lpy.
+Exits with status 1 if any test fails.
+`
+
+//results of running the tests in one file
+type fileResults struct {
+	file    string
+	results []lispy.TestResult
+	//set if the file couldn't be loaded, or a :once fixture failed
+	err      error
+	duration time.Duration
+}
+
+func (f fileResults) failed() int {
+	failed := 0
+	for _, result := range f.results {
+		if !result.Passed() {
+			failed++
+		}
+	}
+	return failed
+}
+
+//runs lispy test with the arguments after test, returning the exit code
+func runTest(args []string, initState func(dir string) *lispy.Env) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "List every test as it is run")
+	run := flags.String("run", "", "Only run tests whose name matches this regular expression")
+	junit := flags.String("junit", "", "Also write the results as JUnit XML to this file")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, testUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	var match func(string) bool
+	if *run != "" {
+		pattern, err := regexp.Compile(*run)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error, invalid -run pattern:", err)
+			return 2
+		}
+		match = pattern.MatchString
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := testFiles(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	all := make([]fileResults, 0)
+	for _, file := range files {
+		res := runTestFile(file, initState(filepath.Dir(file)), match)
+		printResults(res, *verbose)
+		all = append(all, res)
+	}
+	status := printSummary(all)
+	if *junit != "" {
+		if err := writeJUnit(*junit, all); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing JUnit report:", err)
+			return 1
+		}
+	}
+	return status
+}
+
+//finds the test files in paths, files given explicitly are always included
+func testFiles(paths []string) ([]string, error) {
+	files := make([]string, 0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		found, err := lpyFiles([]string{path})
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range found {
+			if strings.HasSuffix(file, "_test.lpy") {
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
+
+//res is named so the deferred duration is set on what's returned, including for files which fail to load
+func runTestFile(file string, env *lispy.Env, match func(string) bool) (res fileResults) {
+	start := time.Now()
+	res = fileResults{file: file}
+	defer func() {
+		res.duration = time.Since(start)
+	}()
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	exprs, err := lispy.Parse(lispy.Read(strings.NewReader(string(source))))
+	if err != nil {
+		if parseErr, isParseErr := err.(*lispy.ParseError); isParseErr {
+			parseErr.File = file
+		}
+		res.err = fmt.Errorf("Error parsing %v", err)
+		return res
+	}
+	if _, err := env.TryEval(exprs); err != nil {
+		res.err = err
+		return res
+	}
+	res.results, res.err = env.RunTests(match)
+	return res
+}
+
+func printResults(res fileResults, verbose bool) {
+	for _, result := range res.results {
+		if result.Passed() {
+			if verbose {
+				fmt.Printf("ok   %s (%s)\n", result.Name, result.Duration)
+			}
+			continue
+		}
+		fmt.Printf("FAIL %s (%s)\n", result.Name, res.file)
+		for _, failure := range result.Failures {
+			printFailure(failure)
+		}
+		if result.Err != nil {
+			fmt.Println("    error:", result.Err)
+		}
+	}
+	switch {
+	case res.err != nil:
+		fmt.Printf("FAIL %s\n    error: %v\n", res.file, res.err)
+	case res.failed() > 0:
+		fmt.Printf("FAIL %s  %d tests, %d failed\n", res.file, len(res.results), res.failed())
+	default:
+		fmt.Printf("ok   %s  %d tests\n", res.file, len(res.results))
+	}
+}
+
+func printFailure(failure lispy.TestFailure) {
+	if len(failure.Context) > 0 {
+		fmt.Println("    in:", strings.Join(failure.Context, " > "))
+	}
+	fmt.Println("    " + failure.Form)
+	if failure.Message != "" {
+		fmt.Println("    " + failure.Message)
+	}
+	if failure.Expected == "" && failure.Actual == "" {
+		return
+	}
+	if !strings.Contains(failure.Expected, "\n") && !strings.Contains(failure.Actual, "\n") {
+		fmt.Println("    expected:", failure.Expected)
+		fmt.Println("      actual:", failure.Actual)
+		return
+	}
+	fmt.Println("    diff (- expected, + actual):")
+	for _, line := range lineDiff(strings.Split(failure.Expected, "\n"), strings.Split(failure.Actual, "\n")) {
+		fmt.Println("    " + line)
+	}
+}
+
+//a line by line diff of two texts using their longest common subsequence, unchanged lines are prefixed with two spaces
+func lineDiff(a []string, b []string) []string {
+	//lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	diff := make([]string, 0)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff = append(diff, "  "+a[i])
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			diff = append(diff, "+ "+b[j])
+			j++
+		default:
+			diff = append(diff, "- "+a[i])
+			i++
+		}
+	}
+	return diff
+}
+
+//prints the totals, returning the exit code
+func printSummary(all []fileResults) int {
+	total, failed, broken := 0, 0, 0
+	for _, res := range all {
+		total += len(res.results)
+		failed += res.failed()
+		if res.err != nil {
+			broken++
+		}
+	}
+	fmt.Printf("Ran %d tests in %d files: %d passed, %d failed", total, len(all), total-failed, failed)
+	if broken > 0 {
+		fmt.Printf(", %d files with errors", broken)
+	}
+	fmt.Println()
+	if failed > 0 || broken > 0 {
+		return 1
+	}
+	return 0
+}
+
+/******* JUnit XML *********/
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func writeJUnit(path string, all []fileResults) error {
+	report := junitSuites{}
+	for _, res := range all {
+		suite := junitSuite{Name: res.file, Tests: len(res.results), Time: seconds(res.duration)}
+		for _, result := range res.results {
+			c := junitCase{Name: result.Name, Classname: res.file, Time: seconds(result.Duration)}
+			if len(result.Failures) > 0 {
+				suite.Failures++
+				text := make([]string, 0)
+				for _, failure := range result.Failures {
+					text = append(text, failureText(failure))
+				}
+				c.Failure = &junitProblem{Message: fmt.Sprintf("%d checks failed", len(result.Failures)), Text: strings.Join(text, "\n\n")}
+			}
+			if result.Err != nil {
+				suite.Errors++
+				c.Error = &junitProblem{Message: result.Err.Error()}
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		if res.err != nil {
+			//report a file which couldn't be run as a test case of its own so it shows up as an error
+			suite.Tests++
+			suite.Errors++
+			suite.Cases = append(suite.Cases, junitCase{Name: filepath.Base(res.file), Classname: res.file, Time: seconds(res.duration), Error: &junitProblem{Message: res.err.Error()}})
+		}
+		report.Suites = append(report.Suites, suite)
+	}
+	out, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(out, '\n')...), 0644)
+}
+
+func failureText(failure lispy.TestFailure) string {
+	lines := make([]string, 0)
+	if len(failure.Context) > 0 {
+		lines = append(lines, "in: "+strings.Join(failure.Context, " > "))
+	}
+	lines = append(lines, failure.Form)
+	if failure.Message != "" {
+		lines = append(lines, failure.Message)
+	}
+	if failure.Expected != "" || failure.Actual != "" {
+		lines = append(lines, "expected: "+failure.Expected, "actual: "+failure.Actual)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,8 @@
+//Package lib is the Lispy standard library, embedded so it ships with the interpreter
+package lib
+
+import "embed"
+
+//FS holds every standard library module, e.g. core.lpy
+//go:embed *.lpy
+var FS embed.FS
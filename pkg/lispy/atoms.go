@@ -0,0 +1,234 @@
+package lispy
+
+import (
+	"sync"
+)
+
+//SexpAtom is a mutable reference which can be safely shared between goroutines
+//all copies of a SexpAtom point to the same underlying atom, so copying stores (as function calls do) keeps them shared
+type SexpAtom struct {
+	ref *atom
+}
+
+type atom struct {
+	mu    sync.Mutex
+	value Sexp
+	//watchers are called with key, atom, old value and new value after every change, in the order they were added
+	watchKeys []string
+	watchers  map[string]watcher
+}
+
+type watcher struct {
+	key      Sexp
+	function FunctionValue
+}
+
+func (a SexpAtom) String() string {
+	return "(atom " + a.deref().String() + ")"
+}
+
+//atoms evaluate to themselves
+func (a SexpAtom) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
+	dec(env)
+	return a
+}
+
+func (a SexpAtom) deref() Sexp {
+	a.ref.mu.Lock()
+	defer a.ref.mu.Unlock()
+	return a.ref.value
+}
+
+//sets the value of the atom if it still holds old (by Lispy equality), returns whether the value was set
+func (a SexpAtom) compareAndSet(env *Env, old Sexp, new Sexp) bool {
+	watchers, set := a.setIfEqual(env, old, new)
+	if set {
+		//call watchers outside the lock so they can deref (or even change) the atom themselves
+		a.notify(env, watchers, old, new)
+	}
+	return set
+}
+
+//comparing can fail on values = doesn't support, so unlock with defer to leave the atom usable after the error
+func (a SexpAtom) setIfEqual(env *Env, old Sexp, new Sexp) ([]watcher, bool) {
+	a.ref.mu.Lock()
+	defer a.ref.mu.Unlock()
+	if !isEqual(env, a.ref.value, old) {
+		return nil, false
+	}
+	a.ref.value = new
+	return a.currentWatchers(), true
+}
+
+//must be called while holding the lock
+func (a SexpAtom) currentWatchers() []watcher {
+	watchers := make([]watcher, 0)
+	for _, key := range a.ref.watchKeys {
+		watchers = append(watchers, a.ref.watchers[key])
+	}
+	return watchers
+}
+
+func (a SexpAtom) notify(env *Env, watchers []watcher, old Sexp, new Sexp) {
+	for _, w := range watchers {
+		callFunction(env, w.function, []Sexp{w.key, a, old, new})
+	}
+}
+
+//identity comparison for references, structural comparison for lists and arrays and Lispy equality (=) for everything else
+func isEqual(env *Env, x Sexp, y Sexp) bool {
+	if isEmptyList(x) || isEmptyList(y) {
+		return isEmptyList(x) && isEmptyList(y)
+	}
+	switch i := x.(type) {
+	case SexpPair:
+		j, isPair := y.(SexpPair)
+		return isPair && isEqual(env, i.head, j.head) && isEqual(env, i.tail, j.tail)
+	case SexpArray:
+		j, isArray := y.(SexpArray)
+		if !isArray || len(i.value) != len(j.value) {
+			return false
+		}
+		for index := range i.value {
+			if !isEqual(env, i.value[index], j.value[index]) {
+				return false
+			}
+		}
+		return true
+	case SexpAtom:
+		j, isAtom := y.(SexpAtom)
+		return isAtom && i.ref == j.ref
+	case SexpChannel:
+		j, isChannel := y.(SexpChannel)
+		return isChannel && i.ch == j.ch
+	case FunctionValue:
+		j, isFunc := y.(FunctionValue)
+		return isFunc && i.defn == j.defn
+	}
+	switch y.(type) {
+	case SexpAtom, SexpChannel, FunctionValue, SexpPair, SexpArray:
+		return false
+	}
+	return getBoolFromTokenType(relationalOperator(env, "=", []Sexp{x, y}))
+}
+
+//lists can end in nil or an empty pair depending on how they were built
+func isEmptyList(x Sexp) bool {
+	switch i := x.(type) {
+	case nil:
+		return true
+	case SexpPair:
+		return i.head == nil && i.tail == nil
+	}
+	return false
+}
+
+//helper function to get an atom out of the argument to an atom builtin
+func getAtom(name string, arg Sexp) SexpAtom {
+	a, isAtom := arg.(SexpAtom)
+	if !isAtom {
+		fatal("Error, ", name, " expects an atom but got ", arg)
+	}
+	return a
+}
+
+/******* atoms *********/
+//(atom v) creates a new atom holding v
+func makeAtom(env *Env, name string, args []Sexp) Sexp {
+	if len(args) != 1 {
+		fatal("Error, ", name, " takes exactly one initial value")
+	}
+	return SexpAtom{ref: &atom{value: args[0], watchers: make(map[string]watcher)}}
+}
+
+//(deref a) or @a returns the current value of the atom
+func deref(env *Env, name string, args []Sexp) Sexp {
+	if len(args) != 1 {
+		fatal("Error, ", name, " takes exactly one atom")
+	}
+	return getAtom(name, args[0]).deref()
+}
+
+//(reset! a v) sets the value of the atom to v regardless of its current value
+func reset(env *Env, name string, args []Sexp) Sexp {
+	if len(args) != 2 {
+		fatal("Error, ", name, " takes an atom and a new value")
+	}
+	a := getAtom(name, args[0])
+	a.ref.mu.Lock()
+	old := a.ref.value
+	a.ref.value = args[1]
+	watchers := a.currentWatchers()
+	a.ref.mu.Unlock()
+	a.notify(env, watchers, old, args[1])
+	return args[1]
+}
+
+//(swap! a f args...) atomically sets the value of the atom to (f current args...) and returns the new value
+//f may be called more than once if another goroutine changes the atom in the meantime, so it should be free of side effects
+func swapAtom(env *Env, name string, args []Sexp) Sexp {
+	if len(args) < 2 {
+		fatal("Error, ", name, " takes an atom and a function")
+	}
+	a := getAtom(name, args[0])
+	function, isFunc := args[1].(FunctionValue)
+	if !isFunc {
+		fatal("Error, the second argument to ", name, " must be a function")
+	}
+	for {
+		a.ref.mu.Lock()
+		old := a.ref.value
+		a.ref.mu.Unlock()
+		new := callFunction(env, function, append([]Sexp{old}, args[2:]...))
+		if a.compareAndSet(env, old, new) {
+			return new
+		}
+	}
+}
+
+//(compare-and-set! a old new) sets the value of the atom to new only if it currently holds old
+func compareAndSetAtom(env *Env, name string, args []Sexp) Sexp {
+	if len(args) != 3 {
+		fatal("Error, ", name, " takes an atom, the expected value and a new value")
+	}
+	return getSexpSymbolFromBool(getAtom(name, args[0]).compareAndSet(env, args[1], args[2]))
+}
+
+//(add-watch a key f) calls (f key a old new) after every change to the atom, replacing any watcher with the same key
+func addWatch(env *Env, name string, args []Sexp) Sexp {
+	if len(args) != 3 {
+		fatal("Error, ", name, " takes an atom, a key and a function")
+	}
+	a := getAtom(name, args[0])
+	function, isFunc := args[2].(FunctionValue)
+	if !isFunc {
+		fatal("Error, the third argument to ", name, " must be a function")
+	}
+	key := args[1].String()
+	a.ref.mu.Lock()
+	defer a.ref.mu.Unlock()
+	if _, found := a.ref.watchers[key]; !found {
+		a.ref.watchKeys = append(a.ref.watchKeys, key)
+	}
+	a.ref.watchers[key] = watcher{key: args[1], function: function}
+	return a
+}
+
+//(remove-watch a key) removes the watcher added with key
+func removeWatch(env *Env, name string, args []Sexp) Sexp {
+	if len(args) != 2 {
+		fatal("Error, ", name, " takes an atom and a key")
+	}
+	a := getAtom(name, args[0])
+	key := args[1].String()
+	a.ref.mu.Lock()
+	defer a.ref.mu.Unlock()
+	delete(a.ref.watchers, key)
+	for i, k := range a.ref.watchKeys {
+		if k == key {
+			a.ref.watchKeys = append(a.ref.watchKeys[:i], a.ref.watchKeys[i+1:]...)
+			break
+		}
+	}
+	return a
+}
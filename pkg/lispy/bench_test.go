@@ -0,0 +1,41 @@
+package lispy
+
+import "testing"
+
+//kept small so that the benchmarks are dominated by the cost of starting up an environment
+const benchSource = `(sum (map (list 1 2 3) square))`
+
+//evaluating a small program with a fresh environment, as a service calling EvalSource per request would
+func BenchmarkEvalSource(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := EvalSource(benchSource); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+//the same program, but evaluating the prelude from scratch for every environment like InitState used to
+func BenchmarkEvalSourceWithoutSnapshot(b *testing.B) {
+	ast, err := evalHelper(benchSource)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		env := newRootEnv()
+		loadLibrary(env)
+		env.steps = 7000
+		env.Eval(ast)
+	}
+}
+
+func BenchmarkInitState(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		InitState()
+	}
+}
+
+func BenchmarkInitStateWithoutSnapshot(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		loadLibrary(newRootEnv())
+	}
+}
@@ -0,0 +1,574 @@
+package lispy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//Diagnostic is a problem found in a program by Check without running it
+type Diagnostic struct {
+	//File is empty unless set by whoever knows where the source was read from
+	File string
+	Line int
+	Msg  string
+}
+
+func (d Diagnostic) String() string {
+	if d.File == "" {
+		return fmt.Sprintf("line %d: %s", d.Line, d.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Msg)
+}
+
+//names which aren't bound in the store but mean something to the evaluator
+var specialForms = map[string]bool{
+	"quote": true, "fn": true, "swap": true, "select": true, "ns": true, "require": true, "&": true,
+	"deftest": true, "testing": true, "is": true, "use-fixtures": true, "let": true, "let*": true, "letrec": true,
+}
+
+//checker resolves the symbols in a program against the prelude and every definition the program makes
+//scoping in Lispy is dynamic, i.e. a function can see the bindings of whoever called it, so a name counts as bound
+//if it is defined (or is a parameter) anywhere in the program
+type checker struct {
+	prelude map[string]Value
+	//every name the program binds, with define, as a parameter or with let
+	bound map[string]bool
+	//names only ever bound as parameters or with let, which could be anything so are never checked for arity
+	params map[string]bool
+	//functions defined by the program, nil if a name is defined more than once with different arities or as a variable
+	functions map[string]arities
+	macros    map[string]bool
+	//aliases (and names) of required modules, whose qualified symbols e.g. m/foo aren't checked
+	modules map[string]bool
+	//how many times each name is referred to
+	references  map[string]int
+	diagnostics []Diagnostic
+}
+
+//Check looks for mistakes in source without running it: calls with the wrong number of arguments, symbols which are
+//never defined, definitions which shadow a builtin or library function, parameters which are never used and cond
+//clauses which can never be reached
+//source must parse, otherwise the ParseError is returned
+func Check(source string) ([]Diagnostic, error) {
+	if _, err := Parse(Read(strings.NewReader(source))); err != nil {
+		return nil, err
+	}
+	nodes := withoutDocs(buildFmtNodes(ReadRaw(strings.NewReader(source))))
+	newPreludeEnv(AllCapabilities)
+	c := &checker{
+		prelude:    preludeStore,
+		bound:      make(map[string]bool),
+		params:     make(map[string]bool),
+		functions:  make(map[string]arities),
+		macros:     make(map[string]bool),
+		modules:    make(map[string]bool),
+		references: make(map[string]int),
+	}
+	for _, node := range nodes {
+		c.collect(node)
+	}
+	for _, node := range nodes {
+		c.check(node, false)
+	}
+	sort.SliceStable(c.diagnostics, func(i, j int) bool {
+		return c.diagnostics[i].Line < c.diagnostics[j].Line
+	})
+	return c.diagnostics, nil
+}
+
+//comments and docstrings make no difference to what a program does, so the checker works on a tree without them
+func withoutDocs(nodes []*fmtNode) []*fmtNode {
+	stripped := make([]*fmtNode, 0)
+	for _, node := range nodes {
+		if node.tok.Token == COMMENT {
+			continue
+		}
+		if node.isList() {
+			copied := *node
+			copied.children = withoutDocs(node.children)
+			if name := headName(&copied); (name == "define" || name == "macro") && len(copied.children) > 3 &&
+				copied.children[2].tok.Token == STRING && startsParamsNode(copied.children[3]) {
+				copied.children = append([]*fmtNode{copied.children[0], copied.children[1]}, copied.children[3:]...)
+			}
+			node = &copied
+		}
+		stripped = append(stripped, node)
+	}
+	return stripped
+}
+
+func (c *checker) report(line int, format string, args ...interface{}) {
+	c.diagnostics = append(c.diagnostics, Diagnostic{Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+//returns the name of a node if it is a plain (unquoted) symbol
+func symbolName(node *fmtNode) (string, bool) {
+	if node.isList() || node.prefix != "" || !isSymbolToken(node.tok.Token) {
+		return "", false
+	}
+	return node.tok.Literal, true
+}
+
+//returns the name at the head of a list, e.g. define for (define x 1)
+func headName(node *fmtNode) string {
+	if !node.isList() || node.open != "(" || node.prefix != "" || len(node.children) == 0 {
+		return ""
+	}
+	name, _ := symbolName(node.children[0])
+	return name
+}
+
+//names in a parameter array, including those of optional parameters e.g. step for (step 1) and in patterns
+func paramNames(node *fmtNode) []string {
+	names := make([]string, 0)
+	for _, param := range paramNodes(node) {
+		if name, isName := symbolName(param); isName {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+//the symbols naming the parameters in a parameter array (or destructuring pattern), including & if it has a rest
+func paramNodes(node *fmtNode) []*fmtNode {
+	nodes := make([]*fmtNode, 0)
+	if node.open != "[" {
+		return nodes
+	}
+	for _, param := range node.children {
+		switch {
+		case param.open == "[":
+			nodes = append(nodes, patternNodes(param)...)
+		case param.open == "(" && len(param.children) > 0 && param.children[0].open == "[":
+			nodes = append(nodes, patternNodes(param.children[0])...)
+		case param.open == "(" && len(param.children) > 0:
+			nodes = append(nodes, param.children[0])
+		default:
+			if _, isName := symbolName(param); isName {
+				nodes = append(nodes, param)
+			}
+		}
+	}
+	return nodes
+}
+
+//the symbols naming the parameters a destructuring pattern binds, without the :keys of one like [:keys name age]
+func patternNodes(node *fmtNode) []*fmtNode {
+	if len(node.children) > 0 {
+		if name, _ := symbolName(node.children[0]); name == ":keys" {
+			return node.children[1:]
+		}
+	}
+	return paramNodes(node)
+}
+
+//the default values of the optional parameters in a parameter array
+func paramDefaults(node *fmtNode) []*fmtNode {
+	defaults := make([]*fmtNode, 0)
+	for _, param := range node.children {
+		if param.open == "(" && len(param.children) > 1 {
+			defaults = append(defaults, param.children[1:]...)
+		}
+	}
+	return defaults
+}
+
+//arity of a parameter array
+func paramsArity(node *fmtNode) arity {
+	var a arity
+	for _, param := range node.children {
+		if param.open == "(" {
+			a.optional++
+		} else if name, _ := symbolName(param); name == "&" {
+			a.variadic = true
+			break
+		} else {
+			a.params++
+		}
+	}
+	return a
+}
+
+//whether node starts the parameters of a function, either a parameter array or a list of one and its body
+func startsParamsNode(node *fmtNode) bool {
+	return node.open == "[" || (node.open == "(" && len(node.children) > 0 && node.children[0].open == "[")
+}
+
+//a parameter array and the body run when a function is called with arguments for it
+type clause struct {
+	params *fmtNode
+	body   *fmtNode
+}
+
+//the parameter lists of a function from what follows its name (or fn), either [params] body or several ([params] body)
+func functionClauses(nodes []*fmtNode) ([]clause, bool) {
+	if len(nodes) == 2 && nodes[0].open == "[" {
+		return []clause{{params: nodes[0], body: nodes[1]}}, true
+	}
+	clauses := make([]clause, 0)
+	for _, node := range nodes {
+		if node.open != "(" || node.prefix != "" || len(node.children) != 2 || node.children[0].open != "[" {
+			return nil, false
+		}
+		clauses = append(clauses, clause{params: node.children[0], body: node.children[1]})
+	}
+	return clauses, len(clauses) > 0
+}
+
+//if node is a function definition (define name [params] body) or (define name ([params] body)...), returns its name
+//and parameter lists
+func functionDefinition(node *fmtNode) (string, []clause, bool) {
+	if headName(node) != "define" || len(node.children) < 3 {
+		return "", nil, false
+	}
+	clauses, isFunction := functionClauses(node.children[2:])
+	name, isName := symbolName(node.children[1])
+	return name, clauses, isFunction && isName
+}
+
+func (c *checker) bindParams(params *fmtNode) {
+	for _, name := range paramNames(params) {
+		if name != "&" {
+			c.bound[name] = true
+			c.params[name] = true
+		}
+	}
+}
+
+//the bindings of a let, either a single one e.g. (x 1) or a list of them e.g. ((x 1) (y 2))
+func letBindingNodes(node *fmtNode) []*fmtNode {
+	if node.open != "(" || node.prefix != "" || len(node.children) == 0 {
+		return nil
+	}
+	if node.children[0].open != "(" {
+		return []*fmtNode{node}
+	}
+	bindings := make([]*fmtNode, 0)
+	for _, binding := range node.children {
+		if binding.open == "(" && len(binding.children) > 0 {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings
+}
+
+//first pass, records everything the program defines and every symbol it refers to
+func (c *checker) collect(node *fmtNode) {
+	if strings.Contains(node.prefix, "'") {
+		return
+	}
+	if !node.isList() {
+		if name, isName := symbolName(node); isName {
+			c.references[name]++
+		}
+		return
+	}
+	children := node.children
+	switch headName(node) {
+	case "define":
+		if len(children) < 2 {
+			break
+		}
+		name, isName := symbolName(children[1])
+		if !isName {
+			break
+		}
+		c.bound[name] = true
+		if _, clauses, isFunction := functionDefinition(node); isFunction {
+			as := make(arities, 0)
+			for _, cl := range clauses {
+				c.bindParams(cl.params)
+				as = append(as, paramsArity(cl.params))
+				for _, defaultVal := range paramDefaults(cl.params) {
+					c.collect(defaultVal)
+				}
+				c.collect(cl.body)
+			}
+			if existing, found := c.functions[name]; !found {
+				c.functions[name] = as
+			} else if existing == nil || existing.String() != as.String() {
+				c.functions[name] = nil
+			}
+			return
+		}
+		//a variable, which could hold a function of any arity
+		c.functions[name] = nil
+		for _, child := range children[2:] {
+			c.collect(child)
+		}
+		return
+	case "macro":
+		if len(children) > 2 {
+			if name, isName := symbolName(children[1]); isName {
+				c.bound[name] = true
+				c.macros[name] = true
+			}
+			c.bindParams(children[2])
+		}
+	case "fn":
+		clauses, _ := functionClauses(children[1:])
+		for _, cl := range clauses {
+			c.bindParams(cl.params)
+		}
+	case "let", "let*", "letrec":
+		if len(children) < 2 {
+			break
+		}
+		for _, binding := range letBindingNodes(children[1]) {
+			//either a name or a destructuring pattern
+			names := []*fmtNode{binding.children[0]}
+			if binding.children[0].open == "[" {
+				names = patternNodes(binding.children[0])
+			}
+			for _, node := range names {
+				if name, isName := symbolName(node); isName {
+					c.bound[name] = true
+					c.params[name] = true
+				}
+			}
+		}
+	case "require":
+		c.collectRequire(node)
+		return
+	case "quote", "quasiquote":
+		return
+	}
+	for _, child := range children {
+		c.collect(child)
+	}
+}
+
+//(require 'my.module :as m :refer [a b]) makes my.module/..., m/..., a and b available
+func (c *checker) collectRequire(node *fmtNode) {
+	args := node.children[1:]
+	if len(args) == 0 {
+		return
+	}
+	c.modules[args[0].tok.Literal] = true
+	for i := 1; i+1 < len(args); i += 2 {
+		switch args[i].tok.Literal {
+		case ":as":
+			c.modules[args[i+1].tok.Literal] = true
+		case ":refer":
+			for _, name := range paramNames(args[i+1]) {
+				c.bound[name] = true
+				c.params[name] = true
+			}
+		}
+	}
+}
+
+//checks a symbol refers to something that exists
+func (c *checker) checkSymbol(node *fmtNode) {
+	name, isName := symbolName(node)
+	if !isName || c.bound[name] || specialForms[name] || strings.HasPrefix(name, ":") {
+		return
+	}
+	if _, found := c.prelude[name]; found {
+		return
+	}
+	if i := strings.Index(name, "/"); i > 0 && c.modules[name[:i]] {
+		return
+	}
+	c.report(node.line, "%s is not defined", name)
+}
+
+//returns the arities of the function name refers to, if they can be known without running the program
+func (c *checker) arityOf(name string) (arities, bool) {
+	if c.params[name] {
+		return nil, false
+	}
+	if as, found := c.functions[name]; found {
+		return as, as != nil
+	}
+	function, isFunc := c.prelude[name].(FunctionValue)
+	if !isFunc || (function.defn.userfunc != nil && function.defn.body == nil) || function.defn.macro {
+		//native builtins check their own arguments
+		return nil, false
+	}
+	as := make(arities, 0)
+	for _, clause := range clausesOf(function.defn) {
+		as = append(as, arityOfParams(clause.arguments))
+	}
+	return as, true
+}
+
+//reports definitions reusing the name of a builtin or library function, since (with dynamic scoping) that replaces it
+//for every function called from there on
+func (c *checker) checkShadowing(node *fmtNode, what string) {
+	name, isName := symbolName(node)
+	if !isName {
+		return
+	}
+	function, isFunc := c.prelude[name].(FunctionValue)
+	if !isFunc {
+		return
+	}
+	kind := "library function"
+	if function.defn.userfunc != nil && function.defn.body == nil {
+		kind = "builtin"
+	}
+	c.report(node.line, "%s %s shadows the %s %s", what, name, kind, name)
+}
+
+//reports shadowing and unused parameters, a parameter counts as used if its name appears anywhere in the program
+//since functions called with it in scope can use it too
+func (c *checker) checkParams(params *fmtNode, function string) {
+	for _, param := range paramNodes(params) {
+		name, isName := symbolName(param)
+		if !isName || name == "&" {
+			continue
+		}
+		c.checkShadowing(param, "parameter")
+		if c.references[name] == 0 && !strings.HasPrefix(name, "_") {
+			c.report(param.line, "parameter %s of %s is never used", name, function)
+		}
+	}
+}
+
+//second pass, reports problems, skipArity is set for the forms passed to -> and ->> which get another argument
+//inserted by the macro
+func (c *checker) check(node *fmtNode, skipArity bool) {
+	if strings.Contains(node.prefix, "'") {
+		return
+	}
+	if !node.isList() {
+		c.checkSymbol(node)
+		return
+	}
+	children := node.children
+	if node.open == "[" || len(children) == 0 {
+		c.checkAll(children)
+		return
+	}
+	name := headName(node)
+	switch name {
+	case "":
+		//a list which doesn't start with a symbol, e.g. calling an anonymous function
+		c.checkAll(children)
+	case "define":
+		if len(children) > 1 {
+			c.checkShadowing(children[1], "define of")
+		}
+		if fname, clauses, isFunction := functionDefinition(node); isFunction {
+			for _, cl := range clauses {
+				c.checkParams(cl.params, fname)
+				c.checkAll(paramDefaults(cl.params))
+				c.check(cl.body, false)
+			}
+		} else if len(children) > 2 {
+			c.checkAll(children[2:])
+		}
+	case "if", "do":
+		c.checkAll(children[1:])
+	case "macro":
+		if len(children) > 3 {
+			c.checkShadowing(children[1], "macro")
+			c.checkAll(children[3:])
+		}
+	case "fn":
+		clauses, isFunction := functionClauses(children[1:])
+		if !isFunction {
+			c.checkAll(children[1:])
+		}
+		for _, cl := range clauses {
+			c.checkParams(cl.params, "fn")
+			c.checkAll(paramDefaults(cl.params))
+			c.check(cl.body, false)
+		}
+	case "let", "let*", "letrec":
+		if len(children) > 1 {
+			for _, binding := range letBindingNodes(children[1]) {
+				c.checkAll(binding.children[1:])
+			}
+		}
+		if len(children) > 2 {
+			c.checkAll(children[2:])
+		}
+	case "select":
+		for _, clause := range children[1:] {
+			if headName(clause) == "default" {
+				c.checkAll(clause.children[1:])
+			} else {
+				c.check(clause, false)
+			}
+		}
+	case "cond":
+		c.checkCond(node)
+		c.checkAll(children[1:])
+	case "->", "->>":
+		for i, child := range children[1:] {
+			c.check(child, i > 0)
+		}
+	case "deftest", "use-fixtures":
+		//the name of a test and :each or :once aren't evaluated
+		c.checkAll(children[2:])
+	case "quote", "quasiquote", "require", "ns":
+	default:
+		c.checkSymbol(children[0])
+		if c.macros[name] {
+			//a macro defined by the program could do anything with its arguments
+			return
+		}
+		if a, known := c.arityOf(name); known && !skipArity && !a.accepts(len(children)-1) {
+			c.report(node.line, "%s expects %s but is called with %d", name, a, len(children)-1)
+		}
+		c.checkAll(children[1:])
+	}
+}
+
+func (c *checker) checkAll(nodes []*fmtNode) {
+	for _, node := range nodes {
+		c.check(node, false)
+	}
+}
+
+//(cond c1 r1 c2 r2 ...) reports clauses after one whose condition is always true or repeats an earlier condition
+func (c *checker) checkCond(node *fmtNode) {
+	clauses := node.children[1:]
+	if len(clauses)%2 != 0 {
+		last := clauses[len(clauses)-1]
+		c.report(last.line, "cond condition %s has no result", flatText(last))
+	}
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(clauses); i += 2 {
+		condition := flatText(clauses[i])
+		if line, found := seen[condition]; found {
+			c.report(clauses[i].line, "cond clause is unreachable, its condition %s is the same as the one on line %d", condition, line)
+			continue
+		}
+		seen[condition] = clauses[i].line
+		if alwaysTrue(clauses[i]) && i+2 < len(clauses) {
+			c.report(clauses[i+2].line, "cond clause is unreachable, the condition %s on line %d is always true", condition, clauses[i].line)
+			return
+		}
+	}
+}
+
+//conditions which can never be false e.g. true or a number
+func alwaysTrue(node *fmtNode) bool {
+	if node.isList() || node.prefix != "" {
+		return false
+	}
+	switch node.tok.Token {
+	case TRUE, INTEGER, FLOAT:
+		return true
+	case STRING:
+		//the empty string is false
+		return node.tok.Literal != `""`
+	}
+	return false
+}
+
+//the source of a node on one line, used to compare conditions
+func flatText(node *fmtNode) string {
+	if !node.isList() {
+		return node.prefix + node.tok.Literal
+	}
+	items := make([]string, 0)
+	for _, child := range node.children {
+		items = append(items, flatText(child))
+	}
+	return node.prefix + node.open + strings.Join(items, " ") + node.close
+}
@@ -0,0 +1,220 @@
+package lispy
+
+import (
+	"fmt"
+	"reflect"
+)
+
+//SexpChannel wraps a Go channel so Lispy values can be passed between goroutines
+type SexpChannel struct {
+	ch chan Sexp
+}
+
+func (c SexpChannel) String() string {
+	return fmt.Sprintf("channel (%d/%d)", len(c.ch), cap(c.ch))
+}
+
+//channels evaluate to themselves
+func (c SexpChannel) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
+	dec(env)
+	return c
+}
+
+//each goroutine gets its own environment so that no two goroutines ever write to the same store
+//the store is copied (like a function call) but the parent is dropped so swap can't reach back into the spawner's Env
+//the debugger only follows the goroutine it was started from so isn't passed on, while the profiler and tracer keep a
+//separate stack (or depth) for the new goroutine
+func newIsolatedEnv(env *Env) *Env {
+	isolated := newFunctionEnv(env)
+	isolated.parent = nil
+	isolated.debugger = nil
+	if env.profiler != nil {
+		isolated.profiler = env.profiler.fork()
+	}
+	if env.trace != nil {
+		isolated.trace = env.trace.fork()
+	}
+	return isolated
+}
+
+//helper function to get a channel out of the argument to a channel builtin
+func getChannel(name string, arg Sexp) SexpChannel {
+	c, isChannel := arg.(SexpChannel)
+	if !isChannel {
+		fatal("Error, ", name, " expects a channel but got ", arg)
+	}
+	return c
+}
+
+//channels can't carry Go nil, so convert the result of something like println into nil
+func nilIfEmpty(val Sexp) Sexp {
+	if val == nil {
+		return SexpSymbol{ofType: FALSE, value: "nil"}
+	}
+	return val
+}
+
+/******* spawn *********/
+//(spawn f args...) runs f in a new goroutine and returns a channel which will receive its result
+func spawn(env *Env, name string, args []Sexp) Sexp {
+	if len(args) == 0 {
+		fatal("Error, ", name, " requires a function to run")
+	}
+	function, isFunc := args[0].(FunctionValue)
+	if !isFunc {
+		fatal("Error, ", name, " can only run a function")
+	}
+	//snapshot the environment before starting the goroutine so the spawner can keep defining things
+	spawnEnv := newIsolatedEnv(env)
+	result := SexpChannel{ch: make(chan Sexp, 1)}
+	go func() {
+		defer close(result.ch)
+		var res Sexp = SexpSymbol{ofType: FALSE, value: "nil"}
+		defer func() {
+			//an error in a goroutine shouldn't bring down the whole interpreter, report it and return nil instead
+			if r := recover(); r != nil {
+				fmt.Fprintln(spawnEnv.ports.err, recoverError(r))
+			}
+			//stop profiling the goroutine before whatever is waiting on its result carries on
+			if spawnEnv.profiler != nil {
+				spawnEnv.profiler.release()
+			}
+			result.ch <- res
+		}()
+		res = nilIfEmpty(callFunction(spawnEnv, function, args[1:]))
+	}()
+	return result
+}
+
+/******* channels *********/
+//(chan) creates an unbuffered channel, (chan n) a channel with a buffer of size n
+func makeChannel(env *Env, name string, args []Sexp) Sexp {
+	size := 0
+	if len(args) > 0 {
+		n, isInt := args[0].(SexpInt)
+		if !isInt || n < 0 {
+			fatal("Error, the buffer size of a channel must be a non-negative integer")
+		}
+		size = int(n)
+	}
+	return SexpChannel{ch: make(chan Sexp, size)}
+}
+
+//(send! c v) blocks until v is sent on c, and returns v
+func send(env *Env, name string, args []Sexp) Sexp {
+	if len(args) != 2 {
+		fatal("Error, ", name, " takes a channel and a value to send")
+	}
+	c := getChannel(name, args[0])
+	c.ch <- nilIfEmpty(args[1])
+	return args[1]
+}
+
+//(recv! c) blocks until a value is received on c, returns nil once c is closed and drained
+func recv(env *Env, name string, args []Sexp) Sexp {
+	if len(args) != 1 {
+		fatal("Error, ", name, " takes exactly one channel")
+	}
+	c := getChannel(name, args[0])
+	val, ok := <-c.ch
+	if !ok {
+		return SexpSymbol{ofType: FALSE, value: "nil"}
+	}
+	return val
+}
+
+func closeChannel(env *Env, name string, args []Sexp) Sexp {
+	if len(args) != 1 {
+		fatal("Error, ", name, " takes exactly one channel")
+	}
+	close(getChannel(name, args[0]).ch)
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
+
+/******* select *********/
+//select is a special form which waits on several channel operations and runs the handler of the first one ready
+//(select
+//    ((recv! c1) (fn [v] ...))
+//    ((send! c2 val) (fn [] ...))
+//    (default expr))
+//handlers are optional, without one the received value (or the sent value) is returned
+func selectStatement(env *Env, name string, args []Sexp) Sexp {
+	clauses, isList := args[0].(SexpPair)
+	if !isList || clauses.head == nil {
+		fatal("Error, select requires at least one clause")
+	}
+	cases := make([]reflect.SelectCase, 0)
+	handlers := make([]Sexp, 0)
+	sent := make([]Sexp, 0)
+	var defaultExpr Sexp
+	hasDefault := false
+	for _, clause := range makeList(clauses) {
+		clauseList, isClause := clause.(SexpPair)
+		if !isClause || clauseList.head == nil {
+			fatal("Error, badly formed select clause: ", clause)
+		}
+		var handler Sexp
+		if rest, hasRest := clauseList.tail.(SexpPair); hasRest {
+			handler = rest.head
+		}
+		if sym, isSym := clauseList.head.(SexpSymbol); isSym && sym.value == "default" {
+			hasDefault = true
+			defaultExpr = handler
+			continue
+		}
+		op, isOp := clauseList.head.(SexpPair)
+		if !isOp {
+			fatal("Error, select clauses must start with a recv! or send! operation")
+		}
+		opArgs := makeList(op)
+		switch opArgs[0].String() {
+		case "recv!":
+			if len(opArgs) != 2 {
+				fatal("Error, recv! in select takes exactly one channel")
+			}
+			c := getChannel("recv!", opArgs[1].Eval(env, &StackFrame{}, false))
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.ch)})
+			sent = append(sent, nil)
+		case "send!":
+			if len(opArgs) != 3 {
+				fatal("Error, send! in select takes a channel and a value")
+			}
+			c := getChannel("send!", opArgs[1].Eval(env, &StackFrame{}, false))
+			val := nilIfEmpty(opArgs[2].Eval(env, &StackFrame{}, false))
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(c.ch), Send: reflect.ValueOf(&val).Elem()})
+			sent = append(sent, val)
+		default:
+			fatal("Error, unknown select operation: ", opArgs[0])
+		}
+		handlers = append(handlers, handler)
+	}
+	if hasDefault {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	}
+	chosen, received, ok := reflect.Select(cases)
+	if hasDefault && chosen == len(cases)-1 {
+		if defaultExpr == nil {
+			return SexpSymbol{ofType: FALSE, value: "nil"}
+		}
+		return defaultExpr.Eval(env, &StackFrame{}, false)
+	}
+	handlerArgs := make([]Sexp, 0)
+	var result Sexp
+	if cases[chosen].Dir == reflect.SelectRecv {
+		result = SexpSymbol{ofType: FALSE, value: "nil"}
+		if ok {
+			result = received.Interface().(Sexp)
+		}
+		handlerArgs = append(handlerArgs, result)
+	} else {
+		result = sent[chosen]
+	}
+	if handlers[chosen] == nil {
+		return result
+	}
+	function, isFunc := handlers[chosen].Eval(env, &StackFrame{}, false).(FunctionValue)
+	if !isFunc {
+		fatal("Error, select handlers must be functions")
+	}
+	return callFunction(env, function, handlerArgs)
+}
@@ -0,0 +1,380 @@
+package lispy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//the debugger works at the granularity of calls to functions defined in Lispy: evaluation pauses just before the body
+//of a call runs, so stepping moves from one call to the next (builtins and macro expansion are never paused in)
+
+//Debugger pauses a program at breakpoints and (break) forms, and reads commands from its input to step through it,
+//inspect the paused call and evaluate expressions in it
+type Debugger struct {
+	in          *bufio.Reader
+	out         io.Writer
+	breakpoints []breakpoint
+	//where to pause next, besides breakpoints
+	mode stepMode
+	//the depth of the call being stepped over or out of
+	depth int
+	//the calls being evaluated, innermost last
+	frames []*debugFrame
+	//set while an expression typed at the prompt is being evaluated, so it runs without pausing
+	evaluating bool
+}
+
+type stepMode int
+
+const (
+	//only pause at breakpoints
+	runToBreakpoint stepMode = iota
+	//pause at the next call
+	stepInto
+	//pause at the next call which isn't inside the current one
+	stepOver
+	//pause when the current call returns
+	stepOut
+)
+
+type debugFrame struct {
+	defn *SexpFunctionLiteral
+	env  *Env
+}
+
+//a breakpoint is either on a function name, or a file and line
+type breakpoint struct {
+	name string
+	file string
+	line int
+}
+
+func (b breakpoint) String() string {
+	if b.name != "" {
+		return b.name
+	}
+	return fmt.Sprintf("%s:%d", b.file, b.line)
+}
+
+//a file:line breakpoint matches calls to any function whose definition spans the line
+func (b breakpoint) matches(defn *SexpFunctionLiteral) bool {
+	if b.name != "" {
+		return defn.name == b.name
+	}
+	return defn.file != "" && (defn.file == b.file || strings.HasSuffix(defn.file, "/"+b.file)) &&
+		defn.line <= b.line && b.line <= defn.endLine
+}
+
+//NewDebugger returns a debugger which reads commands from in and writes to out, attach it with SetDebugger
+func NewDebugger(in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{in: bufio.NewReader(in), out: out}
+}
+
+//SetDebugger attaches d to env so evaluating in it (or any environment created from it) can be paused,
+//a nil debugger detaches it
+func (env *Env) SetDebugger(d *Debugger) {
+	env.debugger = d
+}
+
+//Break adds a breakpoint on a function name, or a file:line
+func (d *Debugger) Break(spec string) error {
+	b := breakpoint{name: spec}
+	if idx := strings.LastIndex(spec, ":"); idx > 0 {
+		if line, err := strconv.Atoi(spec[idx+1:]); err == nil {
+			b = breakpoint{file: spec[:idx], line: line}
+		}
+	}
+	if b.name == "" && b.file == "" {
+		return fmt.Errorf("expected a function name or file:line but got %q", spec)
+	}
+	d.breakpoints = append(d.breakpoints, b)
+	return nil
+}
+
+//Pause makes the debugger pause at the next call
+func (d *Debugger) Pause() {
+	d.mode = stepInto
+}
+
+//evaluates the body of the call in thunk, pausing before it runs or after it returns if need be
+func (d *Debugger) call(thunk FunctionThunkValue) Sexp {
+	defn := thunk.function.defn
+	if d.evaluating {
+		return defn.body.Eval(thunk.env, &StackFrame{}, true)
+	}
+	frame := &debugFrame{defn: defn, env: thunk.env}
+	d.frames = append(d.frames, frame)
+	returned := false
+	defer func() {
+		//the call failed part way through
+		if !returned {
+			d.frames = d.frames[:len(d.frames)-1]
+		}
+	}()
+	if d.shouldPause(frame) {
+		d.pause(frame.env, "")
+	}
+	res := defn.body.Eval(thunk.env, &StackFrame{}, true)
+	returned = true
+	d.frames = d.frames[:len(d.frames)-1]
+	//a tail call returns a thunk which carries on at the same depth, so it hasn't really returned yet
+	if _, isTail := res.(FunctionThunkValue); !isTail && d.mode == stepOut && len(d.frames) < d.depth {
+		//pause back in the caller, whose environment the call's was created from
+		callerEnv := thunk.env
+		if callerEnv.parent != nil {
+			callerEnv = callerEnv.parent
+		}
+		d.pause(callerEnv, fmt.Sprintf("%s returned %s", defn.name, readableString(nilIfEmpty(res))))
+	}
+	return res
+}
+
+func (d *Debugger) shouldPause(frame *debugFrame) bool {
+	for _, b := range d.breakpoints {
+		if b.matches(frame.defn) {
+			return true
+		}
+	}
+	switch d.mode {
+	case stepInto:
+		return true
+	case stepOver:
+		return len(d.frames) <= d.depth
+	}
+	return false
+}
+
+//(break) pauses the program if it is being run with a debugger, and does nothing otherwise
+func breakStatement(env *Env, name string, args []Sexp) Sexp {
+	d := env.debugger
+	if d != nil && !d.evaluating {
+		//builtins are called in a copy of the caller's environment, pause in the caller's so definitions stick
+		if env.parent != nil {
+			env = env.parent
+		}
+		d.pause(env, "(break)")
+	}
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
+
+const debugHelp = `  s, step         step into the next call
+  n, next         step over this call, pausing at the next one that isn't inside it
+  o, out          run until this call returns
+  c, continue     run until the next breakpoint
+  b, break [spec] add a breakpoint on a function name or file:line, or list the breakpoints
+  d, delete n     delete breakpoint n
+  l, locals       show the arguments and local definitions of this call
+  env             show everything defined in this call's environment, apart from the builtins and library
+  bt, where       show the calls which led to this one
+  p expr          evaluate expr in this call (anything which isn't a command is evaluated too)
+  q, quit         stop the program
+`
+
+//reads commands until one resumes the program, event describes why it paused if it isn't the start of a call
+func (d *Debugger) pause(env *Env, event string) {
+	d.mode = runToBreakpoint
+	d.printLocation(event)
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		line, err := d.in.ReadString('\n')
+		if err != nil && line == "" {
+			//nobody left to type commands, so run the rest of the program without stopping
+			fmt.Fprintln(d.out)
+			d.breakpoints = nil
+			return
+		}
+		line = strings.TrimSpace(line)
+		command, arg := line, ""
+		if idx := strings.IndexAny(line, " \t"); idx >= 0 {
+			command, arg = line[:idx], strings.TrimSpace(line[idx+1:])
+		}
+		switch command {
+		case "":
+		case "s", "step":
+			d.mode = stepInto
+			return
+		case "n", "next":
+			d.mode, d.depth = stepOver, len(d.frames)
+			return
+		case "o", "out":
+			d.mode, d.depth = stepOut, len(d.frames)
+			return
+		case "c", "continue":
+			return
+		case "b", "break":
+			if arg == "" {
+				d.printBreakpoints()
+			} else if err := d.Break(arg); err != nil {
+				fmt.Fprintln(d.out, err)
+			}
+		case "d", "delete":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 || n > len(d.breakpoints) {
+				fmt.Fprintln(d.out, "expected the number of a breakpoint, see b")
+				continue
+			}
+			d.breakpoints = append(d.breakpoints[:n-1], d.breakpoints[n:]...)
+		case "l", "locals":
+			d.printLocals(env)
+		case "env":
+			d.printEnv(env)
+		case "bt", "where":
+			d.printBacktrace()
+		case "q", "quit":
+			d.breakpoints = nil
+			fatal("Program stopped from the debugger")
+		case "h", "help":
+			fmt.Fprint(d.out, debugHelp)
+		case "p":
+			d.evaluate(env, arg)
+		default:
+			d.evaluate(env, line)
+		}
+	}
+}
+
+func (d *Debugger) evaluate(env *Env, source string) {
+	exprs, err := Parse(Read(strings.NewReader(source)))
+	if err != nil {
+		fmt.Fprintln(d.out, "Error parsing", err)
+		return
+	}
+	d.evaluating = true
+	res, err := env.TryEval(exprs)
+	d.evaluating = false
+	for _, r := range res {
+		fmt.Fprintln(d.out, r)
+	}
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+	}
+}
+
+func (d *Debugger) printLocation(event string) {
+	if event != "" {
+		fmt.Fprint(d.out, event)
+		if len(d.frames) == 0 {
+			fmt.Fprintln(d.out, " at the top level")
+			return
+		}
+		fmt.Fprint(d.out, " in ")
+	}
+	fmt.Fprintln(d.out, describeFrame(d.frames[len(d.frames)-1]))
+}
+
+//e.g. fact(n=3) at fact.lpy:2
+func describeFrame(frame *debugFrame) string {
+	args := make([]string, 0)
+	for _, name := range boundNames(frame.defn.arguments) {
+		args = append(args, name+"="+readableString(lookupValue(frame.env, name)))
+	}
+	desc := frame.defn.name + "(" + strings.Join(args, " ") + ")"
+	switch {
+	case frame.defn.file != "":
+		desc += fmt.Sprintf(" at %s:%d", frame.defn.file, frame.defn.line)
+	case frame.defn.line != 0:
+		desc += fmt.Sprintf(" at line %d", frame.defn.line)
+	}
+	return desc
+}
+
+func lookupValue(env *Env, name string) Sexp {
+	if val, found := env.lookup(name); found {
+		if s, isSexp := val.(Sexp); isSexp {
+			return nilIfEmpty(s)
+		}
+	}
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
+
+func (d *Debugger) printBreakpoints() {
+	if len(d.breakpoints) == 0 {
+		fmt.Fprintln(d.out, "no breakpoints")
+	}
+	for i, b := range d.breakpoints {
+		fmt.Fprintf(d.out, "%d: %s\n", i+1, b)
+	}
+}
+
+func (d *Debugger) printBacktrace() {
+	for i := len(d.frames) - 1; i >= 0; i-- {
+		fmt.Fprintf(d.out, "%d: %s\n", len(d.frames)-1-i, describeFrame(d.frames[i]))
+	}
+	fmt.Fprintln(d.out, "   top level")
+}
+
+//the arguments of the paused call, followed by anything defined in it
+func (d *Debugger) printLocals(env *Env) {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	//a call's environment starts as a copy of its caller's, so anything which differs was defined in the call
+	caller := env.parent
+	if len(d.frames) > 0 {
+		frame := d.frames[len(d.frames)-1]
+		//env may be one created inside the call e.g. for a builtin which called the one paused in
+		for curr := env; curr != nil; curr = curr.parent {
+			if curr != frame.env {
+				continue
+			}
+			for _, name := range boundNames(frame.defn.arguments) {
+				names = append(names, name)
+				seen[name] = true
+			}
+			caller = frame.env.parent
+			break
+		}
+	}
+	if caller != nil {
+		defined := make([]string, 0)
+		for name, val := range env.store {
+			if seen[name] {
+				continue
+			}
+			if old, found := caller.lookup(name); !found || !sameValue(env, old, val) {
+				defined = append(defined, name)
+			}
+		}
+		sort.Strings(defined)
+		names = append(names, defined...)
+	}
+	d.printBindings(env, names)
+}
+
+//everything which isn't part of the prelude, or has been redefined
+func (d *Debugger) printEnv(env *Env) {
+	names := make([]string, 0)
+	for _, name := range env.Bindings() {
+		val, _ := env.lookup(name)
+		if old, found := preludeStore[name]; !found || !sameValue(env, old, val) {
+			names = append(names, name)
+		}
+	}
+	d.printBindings(env, names)
+}
+
+func (d *Debugger) printBindings(env *Env, names []string) {
+	if len(names) == 0 {
+		fmt.Fprintln(d.out, "nothing defined")
+	}
+	for _, name := range names {
+		fmt.Fprintf(d.out, "%s = %s\n", name, readableString(lookupValue(env, name)))
+	}
+}
+
+func sameValue(env *Env, a Value, b Value) bool {
+	x, isSexp := a.(Sexp)
+	y, isOtherSexp := b.(Sexp)
+	if !isSexp || !isOtherSexp {
+		return a == b
+	}
+	equal := false
+	//comparing values of different types can fail, in which case they certainly aren't the same
+	env.try(func() {
+		equal = isEqual(env, x, y)
+	})
+	return equal
+}
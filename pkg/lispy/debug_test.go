@@ -0,0 +1,73 @@
+package lispy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const debugSource = `(define helper [x] (* x 2))
+(define twice [n]
+  (+ (helper n) (helper n)))
+(define main []
+  (do
+    (define local 1)
+    (break)
+    (twice 5)))
+(main)`
+
+func TestDebugger(t *testing.T) {
+	tests := []struct {
+		name        string
+		breakpoints []string
+		commands    string
+		want        string
+	}{
+		{
+			name:     "pauses at (break) and evaluates in the paused call",
+			commands: "l\n(+ local 1)\nc\n",
+			want:     "(break) in main() at debug.lpy:4\n(debug) local = 1\n(debug) 2\n(debug) ",
+		},
+		{
+			//twice is called in tail position, so it replaces main rather than being called from it
+			name:        "breakpoint on a function name, step into and out",
+			breakpoints: []string{"twice"},
+			commands:    "c\ns\no\nbt\nc\n",
+			want: "(break) in main() at debug.lpy:4\n(debug) twice(n=5) at debug.lpy:2\n" +
+				"(debug) helper(x=5) at debug.lpy:1\n(debug) helper returned 10 in twice(n=5) at debug.lpy:2\n" +
+				"(debug) 0: twice(n=5) at debug.lpy:2\n   top level\n(debug) ",
+		},
+		{
+			name:        "breakpoint on a line and step over",
+			breakpoints: []string{"debug.lpy:3"},
+			commands:    "c\nn\n",
+			want:        "(break) in main() at debug.lpy:4\n(debug) twice(n=5) at debug.lpy:2\n(debug) ",
+		},
+	}
+	for _, test := range tests {
+		exprs, err := Parse(Read(strings.NewReader(debugSource)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		d := NewDebugger(strings.NewReader(test.commands), &out)
+		for _, spec := range test.breakpoints {
+			if err := d.Break(spec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		env := InitState()
+		env.SetDebugger(d)
+		res, err := env.TryEval(WithFile(exprs, "debug.lpy"))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := res[len(res)-1]; got != "20" {
+			t.Errorf("%s: the program returned %s, expected 20", test.name, got)
+		}
+		if out.String() != test.want {
+			t.Errorf("%s: the debugger printed\n%s\nexpected\n%s", test.name, out.String(), test.want)
+		}
+	}
+}
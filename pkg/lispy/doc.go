@@ -0,0 +1,235 @@
+package lispy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//documentation lives on function literals: a define or macro can have a docstring between its name and parameters
+//e.g. (define square "Multiplies x by itself" [x] (* x x)), and native builtins are given theirs when registered
+
+//the documentation of a native builtin, whose parameters are written as they would be in a definition
+type builtinDoc struct {
+	params string
+	doc    string
+}
+
+//returns the documentation of every builtin in returnDefinedFunctions
+func returnBuiltinDocs() map[string]builtinDoc {
+	docs := make(map[string]builtinDoc)
+	docs["car"] = builtinDoc{"[list]", "Returns the first element of list."}
+	docs["cdr"] = builtinDoc{"[list]", "Returns everything in list after the first element."}
+	docs["cons"] = builtinDoc{"[x list]", "Returns a new list with x followed by the elements of list."}
+	docs["+"] = builtinDoc{"[x & more]", "Adds the numbers together."}
+	docs["-"] = builtinDoc{"[x & more]", "Subtracts the rest of the numbers from x."}
+	docs["/"] = builtinDoc{"[x & more]", "Divides x by the rest of the numbers in turn."}
+	docs["*"] = builtinDoc{"[x & more]", "Multiplies the numbers together."}
+	docs["#"] = builtinDoc{"[x y]", "Raises x to the power of y."}
+	docs["%"] = builtinDoc{"[x y]", "Returns the remainder of dividing x by y."}
+	docs["="] = builtinDoc{"[x y]", "Whether x and y are equal."}
+	docs[">="] = builtinDoc{"[x y]", "Whether x is greater than or equal to y."}
+	docs["<="] = builtinDoc{"[x y]", "Whether x is less than or equal to y."}
+	docs[">"] = builtinDoc{"[x y]", "Whether x is greater than y."}
+	docs["<"] = builtinDoc{"[x y]", "Whether x is less than y."}
+	docs["and"] = builtinDoc{"[x y & more]", "Whether every argument is true, all of them are evaluated."}
+	docs["or"] = builtinDoc{"[x y & more]", "Whether any argument is true, all of them are evaluated."}
+	docs["not"] = builtinDoc{"[x]", "Whether x is false."}
+	docs["print"] = builtinDoc{"[& xs]", "Writes the display form of xs separated by spaces."}
+	docs["println"] = builtinDoc{"[& xs]", "Writes the display form of xs separated by spaces, followed by a newline."}
+	docs["pr"] = builtinDoc{"[& xs]", "Writes the readable form of xs separated by spaces, so e.g. strings keep their quotes."}
+	docs["prn"] = builtinDoc{"[& xs]", "Writes the readable form of xs separated by spaces, followed by a newline."}
+	docs["printf"] = builtinDoc{"[format & args]", "Writes args formatted with Go-style verbs e.g. (printf \"%s is %d\\n\" \"x\" 5)."}
+	docs["pprint"] = builtinDoc{"[x & width]", "Writes x readably over as many lines as it needs to fit in 80 columns, or width."}
+	docs["assert="] = builtinDoc{"[expected actual & message]", "Checks two values are equal in a test."}
+	docs["break"] = builtinDoc{"[]", "Pauses the program if it is being run with a debugger."}
+	docs["trace"] = builtinDoc{"[& fs]", "Logs every call to the functions fs with their results, or every call if none are given."}
+	docs["untrace"] = builtinDoc{"[& fs]", "Stops logging calls to fs, or stops tracing altogether if none are given."}
+	docs["doc"] = builtinDoc{"[x]", "Writes the signature and documentation of a function, or the name of one."}
+	docs["format"] = builtinDoc{"[format & args]", "Returns args formatted with Go-style verbs e.g. (format \"%.2f\" 3.14159)."}
+	docs["list"] = builtinDoc{"[& xs]", "Returns a list of xs."}
+	docs["type"] = builtinDoc{"[x]", "Returns the type of x as a string e.g. \"int\" or \"list\"."}
+	docs["quote"] = builtinDoc{"[x]", "Returns x without evaluating it, 'x is shorthand for (quote x)."}
+	docs["rand"] = builtinDoc{"[]", "Returns a random float between 0 and 1."}
+	docs["number"] = builtinDoc{"[x]", "Converts a string or number to a float."}
+	docs["symbol"] = builtinDoc{"[x]", "Returns a symbol named by the display form of x."}
+	docs["gensym"] = builtinDoc{"[]", "Generates a unique symbol which has not been defined before."}
+	docs["readline"] = builtinDoc{"[& prompt]", "Writes prompt if given and returns the next line of input, without the newline."}
+	docs["str"] = builtinDoc{"[& xs]", "Joins the display form of xs into a string."}
+	docs["quote?"] = builtinDoc{"[x]", "Whether x is a quote."}
+	docs["applyTo"] = builtinDoc{"[f args]", "Calls f with the elements of the list args as its arguments."}
+	docs["readstring"] = builtinDoc{"[s]", "Reads the first expression in the string s, without evaluating it."}
+	docs["spawn"] = builtinDoc{"[f & args]", "Calls f with args in a new goroutine, returning a channel which will receive the result."}
+	docs["go"] = docs["spawn"]
+	docs["chan"] = builtinDoc{"[& size]", "Returns a new channel, buffered if a size is given."}
+	docs["send!"] = builtinDoc{"[c x]", "Blocks until x is sent on the channel c, and returns x."}
+	docs["recv!"] = builtinDoc{"[c]", "Blocks until a value is received on the channel c, returns nil once it is closed and drained."}
+	docs["close!"] = builtinDoc{"[c]", "Closes the channel c."}
+	docs["atom"] = builtinDoc{"[x]", "Returns a new atom holding x."}
+	docs["deref"] = builtinDoc{"[a]", "Returns the value of the atom a, @a is shorthand for (deref a)."}
+	docs["reset!"] = builtinDoc{"[a x]", "Sets the value of the atom a to x."}
+	docs["swap!"] = builtinDoc{"[a f & args]", "Atomically sets the value of the atom a to (f value args...) and returns it."}
+	docs["compare-and-set!"] = builtinDoc{"[a old new]", "Sets the value of the atom a to new only if it holds old, returning whether it did."}
+	docs["add-watch"] = builtinDoc{"[a key f]", "Calls (f key a old new) after every change to the atom a."}
+	docs["remove-watch"] = builtinDoc{"[a key]", "Removes the watcher added to the atom a with key."}
+	docs["save-image"] = builtinDoc{"[path]", "Writes everything defined in the calling environment to the file at path."}
+	docs["load-image"] = builtinDoc{"[path]", "Defines everything saved in the image at path."}
+	return docs
+}
+
+//the parameters of a function as written in its definition e.g. [x & rest]
+func paramsString(defn *SexpFunctionLiteral) string {
+	if defn.userfunc != nil && defn.body == nil {
+		return defn.nativeParams
+	}
+	params := make([]string, 0)
+	for _, arg := range defn.arguments.value {
+		params = append(params, readableString(arg))
+	}
+	return "[" + strings.Join(params, " ") + "]"
+}
+
+//e.g. (map arr func), or (car ...) for a builtin whose parameters aren't documented, and each of them joined with or
+//for a function with several parameter lists e.g. (f x) or (f x y)
+func signature(name string, defn *SexpFunctionLiteral) string {
+	if len(defn.arities) > 0 {
+		signatures := make([]string, 0)
+		for i := range defn.arities {
+			signatures = append(signatures, signature(name, &defn.arities[i]))
+		}
+		return strings.Join(signatures, " or ")
+	}
+	params := strings.TrimSuffix(strings.TrimPrefix(paramsString(defn), "["), "]")
+	if defn.userfunc != nil && defn.body == nil && defn.nativeParams == "" {
+		params = "..."
+	}
+	if params == "" {
+		return "(" + name + ")"
+	}
+	return "(" + name + " " + params + ")"
+}
+
+//describes what is bound to name: its signature, what kind of thing it is and its documentation, indented below
+func describe(env *Env, name string, val Value) string {
+	function, isFunc := val.(FunctionValue)
+	if !isFunc {
+		sexp, _ := val.(Sexp)
+		typeName := typeOf(env, "type", []Sexp{sexp}).String()
+		return fmt.Sprintf("%s\n  %s", name, typeName)
+	}
+	kind := "function"
+	switch {
+	case function.defn.userfunc != nil && function.defn.body == nil:
+		kind = "native builtin"
+	case function.defn.macro:
+		kind = "macro"
+	}
+	if function.home != nil && function.home.module != nil {
+		kind += " from " + function.home.module.name
+	}
+	description := signature(name, function.defn) + "\n  " + kind
+	if function.defn.doc != "" {
+		description += "\n  " + strings.ReplaceAll(function.defn.doc, "\n", "\n  ")
+	}
+	return description
+}
+
+/******* doc *********/
+//(doc f) or (doc 'f) writes the signature and documentation of f
+func docStatement(env *Env, name string, args []Sexp) Sexp {
+	if len(args) != 1 {
+		fatal("Error, ", name, " expects a function or the name of one")
+	}
+	var description string
+	switch i := args[0].(type) {
+	case FunctionValue:
+		description = describe(env, i.defn.name, i)
+	case SexpSymbol:
+		if i.ofType != SYMBOL {
+			fatal("Error, ", name, " expects a function or the name of one but got ", readableString(i))
+		}
+		val, found := env.lookup(i.value)
+		if !found {
+			fatal("Error, ", i.value, " is not defined")
+		}
+		description = describe(env, i.value, val)
+	default:
+		fatal("Error, ", name, " expects a function or the name of one but got ", readableString(args[0]))
+	}
+	fmt.Fprintln(env.ports.out, description)
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
+
+//DocEntry documents a definition for reference pages, e.g. those written by lispy doc
+type DocEntry struct {
+	Name string
+	//function, macro, variable or native builtin
+	Kind string
+	//how it is called e.g. (map arr func), just the name for a variable
+	Signature string
+	Doc       string
+}
+
+//ModuleDocs returns an entry for everything source defines at the top level, in the order they're defined
+//if source declares its exports with (ns name [exports...]), only those are included
+func ModuleDocs(source string) ([]DocEntry, error) {
+	nodes, err := Parse(Read(strings.NewReader(source)))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]DocEntry, 0)
+	var exports map[string]bool
+	for _, node := range nodes {
+		switch i := node.(type) {
+		case SexpPair:
+			//a function definition is parsed as a literal inside the list it was written in
+			if defn, isDefn := i.head.(SexpFunctionLiteral); isDefn {
+				kind := "function"
+				if defn.macro {
+					kind = "macro"
+				}
+				entries = append(entries, DocEntry{Name: defn.name, Kind: kind, Signature: signature(defn.name, &defn), Doc: defn.doc})
+				continue
+			}
+			//(define name value) and (ns name [exports...]) are just lists
+			head, isSymbol := i.head.(SexpSymbol)
+			tail, isPair := i.tail.(SexpPair)
+			if !isSymbol || !isPair {
+				continue
+			}
+			if name, isName := tail.head.(SexpSymbol); head.ofType == DEFINE && isName && name.ofType == SYMBOL {
+				entries = append(entries, DocEntry{Name: name.value, Kind: "variable", Signature: name.value})
+			} else if rest, hasExports := tail.tail.(SexpPair); head.value == "ns" && hasExports {
+				if arr, isArray := rest.head.(SexpArray); isArray {
+					exports = make(map[string]bool)
+					for _, export := range arr.value {
+						exports[export.String()] = true
+					}
+				}
+			}
+		}
+	}
+	if exports == nil {
+		return entries, nil
+	}
+	exported := make([]DocEntry, 0)
+	for _, entry := range entries {
+		if exports[entry.Name] {
+			exported = append(exported, entry)
+		}
+	}
+	return exported, nil
+}
+
+//BuiltinDocs returns an entry for every native builtin, sorted by name
+func BuiltinDocs() []DocEntry {
+	entries := make([]DocEntry, 0)
+	for name, val := range newRootEnv().store {
+		defn := val.(FunctionValue).defn
+		entries = append(entries, DocEntry{Name: name, Kind: "native builtin", Signature: signature(name, defn), Doc: defn.doc})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
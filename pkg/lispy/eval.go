@@ -1,10 +1,17 @@
 package lispy
 
 import (
-	"errors"
+	"bufio"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+
+	"github.com/amirgamil/lispy/lib"
 )
 
 type Env struct {
@@ -13,6 +20,55 @@ type Env struct {
 	store  map[string]Value
 	//used to track stack limit for safety
 	steps int
+	//streams used by IO builtins, shared by every environment created from the same InitState
+	ports *ports
+	//modules loaded by require, shared by every environment created from the same InitState
+	modules *modules
+	//set on the top-level environment of a module
+	module *module
+	//tests defined with deftest, shared by every environment created from the same InitState
+	tests *tests
+	//set when running under a debugger, or profiler
+	debugger *Debugger
+	profiler *Profiler
+	//functions being traced, shared by every environment created from the same InitState (each goroutine has its own
+	//tracer to keep the depth of its calls)
+	trace *tracer
+}
+
+//ports are the input and output streams an interpreter reads from and writes to
+type ports struct {
+	in  *bufio.Reader
+	out io.Writer
+	err io.Writer
+}
+
+//SetInput changes the reader used by builtins like readline
+func (env *Env) SetInput(r io.Reader) {
+	env.ports.in = bufio.NewReader(r)
+}
+
+//SetOutput changes the writer used by builtins like println
+func (env *Env) SetOutput(w io.Writer) {
+	env.ports.out = w
+}
+
+//SetError changes the writer used to report errors
+func (env *Env) SetError(w io.Writer) {
+	env.ports.err = w
+}
+
+//Bindings returns the names of everything defined in env (including the builtins and library), in sorted order
+func (env *Env) Bindings() []string {
+	names := make([]string, 0, len(env.store))
+	for key := range env.store {
+		//anonymous functions are stored under fn when evaluated and are never referred to by name
+		if key != "fn" {
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+	return names
 }
 
 //Value is a reference to any Value in a Lispy program
@@ -23,6 +79,8 @@ type Value interface {
 //Value referencing any functions
 type FunctionValue struct {
 	defn *SexpFunctionLiteral
+	//environment of the module which defined the function, nil for functions which run in the caller's environment
+	home *Env
 }
 
 //struct to store function arguments for now
@@ -64,37 +122,168 @@ func returnDefinedFunctions() map[string]LispyUserFunction {
 	functions["and"] = and
 	functions["or"] = or
 	functions["not"] = not
+	functions["print"] = printStatement
 	functions["println"] = printlnStatement
+	functions["pr"] = prStatement
+	functions["prn"] = prnStatement
+	functions["printf"] = printfStatement
+	functions["pprint"] = pprint
+	functions["assert="] = assertEqual
+	functions["break"] = breakStatement
+	functions["trace"] = trace
+	functions["untrace"] = untrace
+	functions["doc"] = docStatement
+	functions["format"] = format
 	functions["list"] = createList
 	functions["type"] = typeOf
 	functions["quote"] = quote
 	functions["rand"] = random
 	functions["number"] = number
 	functions["symbol"] = symbol
+	functions["gensym"] = gensym
 	functions["readline"] = readline
 	functions["str"] = str
 	functions["quote?"] = isQuote
 	functions["applyTo"] = applyTo
 	functions["readstring"] = readstring
+	functions["spawn"] = spawn
+	functions["go"] = spawn
+	functions["chan"] = makeChannel
+	functions["send!"] = send
+	functions["recv!"] = recv
+	functions["close!"] = closeChannel
+	functions["atom"] = makeAtom
+	functions["deref"] = deref
+	functions["reset!"] = reset
+	functions["swap!"] = swapAtom
+	functions["compare-and-set!"] = compareAndSetAtom
+	functions["add-watch"] = addWatch
+	functions["remove-watch"] = removeWatch
+	functions["save-image"] = saveImage
+	functions["load-image"] = loadImage
 	return functions
 }
 
-func InitState() *Env {
+//Capability names a group of builtins an embedder can grant to an environment
+type Capability string
+
+//pure builtins only compute over their arguments and are always safe to expose
+const CapPure Capability = "pure"
+const CapIO Capability = "io"
+const CapFS Capability = "fs"
+const CapRandom Capability = "random"
+
+//AllCapabilities is the set granted to an environment when InitState is called without any
+var AllCapabilities = []Capability{CapPure, CapIO, CapFS, CapRandom}
+
+//returns the capability each builtin requires, any builtin not listed here is pure
+func returnBuiltinCapabilities() map[string]Capability {
+	capabilities := make(map[string]Capability)
+	capabilities["print"] = CapIO
+	capabilities["println"] = CapIO
+	capabilities["pr"] = CapIO
+	capabilities["prn"] = CapIO
+	capabilities["printf"] = CapIO
+	capabilities["pprint"] = CapIO
+	capabilities["readline"] = CapIO
+	capabilities["trace"] = CapIO
+	capabilities["untrace"] = CapIO
+	capabilities["doc"] = CapIO
+	capabilities["rand"] = CapRandom
+	capabilities["save-image"] = CapFS
+	capabilities["load-image"] = CapFS
+	return capabilities
+}
+
+//stub installed in place of a builtin the environment was not granted, so calling it fails loudly
+func notPermitted(capability Capability) LispyUserFunction {
+	return func(env *Env, name string, args []Sexp) Sexp {
+		fatal("Error, ", name, " is not permitted in this environment (requires the ", capability, " capability)")
+		return nil
+	}
+}
+
+//InitState creates a new environment loaded with the builtins matching the given capabilities and the library
+//if no capabilities are passed, every builtin is installed
+func InitState(caps ...Capability) *Env {
+	if len(caps) == 0 {
+		caps = AllCapabilities
+	}
+	env := newPreludeEnv(caps)
+	env.ports = &ports{in: bufio.NewReader(os.Stdin), out: os.Stdout, err: os.Stderr}
+	env.modules = &modules{path: []string{"."}, cache: make(map[string]*module), caps: caps}
+	env.tests = &tests{}
+	env.trace = newTracer()
+	return env
+}
+
+//the prelude (builtins and standard library) is only lexed, parsed and evaluated once, after which
+//every new environment starts with a copy of the resulting store, which is much cheaper than evaluating it again
+var preludeOnce sync.Once
+var preludeStore map[string]Value
+
+//set if the prelude failed to load, which is reported by every call to newPreludeEnv since the Once won't try again
+var preludeErr error
+
+//creates an environment with the builtins matching the given capabilities and the standard library prelude
+func newPreludeEnv(caps []Capability) *Env {
+	preludeOnce.Do(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				preludeErr = recoverError(r)
+			}
+		}()
+		root := newRootEnv()
+		loadLibrary(root)
+		preludeStore = root.store
+	})
+	if preludeErr != nil {
+		panic(preludeErr)
+	}
+	granted := map[Capability]bool{CapPure: true}
+	for _, capability := range caps {
+		granted[capability] = true
+	}
+	env := new(Env)
+	//values in the store are never mutated in place (define just replaces the binding), so a shallow copy is enough
+	env.store = make(map[string]Value, len(preludeStore))
+	for key, val := range preludeStore {
+		env.store[key] = val
+	}
+	for key, capability := range returnBuiltinCapabilities() {
+		if !granted[capability] {
+			env.store[key] = makeUserFunction(key, notPermitted(capability))
+		}
+	}
+	env.steps = maxSteps
+	return env
+}
+
+//creates an environment with just the builtins
+func newRootEnv() *Env {
 	//add more ops as need for function bodies, assignments etc
 	env := new(Env)
 	env.store = make(map[string]Value)
+	docs := returnBuiltinDocs()
 	for key, function := range returnDefinedFunctions() {
-		env.store[key] = makeUserFunction(key, function)
+		builtin := makeUserFunction(key, function)
+		builtin.defn.nativeParams, builtin.defn.doc = docs[key].params, docs[key].doc
+		env.store[key] = builtin
 	}
 	env.steps = maxSteps
-	//load library functions
-	errLib := EvalSourceIO(lib, env)
-	if errLib != nil {
-		log.Fatal("Error loading library packages of lispy")
-	}
 	return env
 }
 
+//load library functions
+func loadLibrary(env *Env) {
+	for _, name := range prelude {
+		errLib := loadFile(lib.FS, name, env)
+		if errLib != nil {
+			fatal("Error loading library packages of lispy: ", errLib)
+		}
+	}
+}
+
 func (s SexpSymbol) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
 	dec(env)
 	switch s.ofType {
@@ -116,23 +305,42 @@ func (s SexpSymbol) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
 		//if no argument then it's a variable
 		if len(frame.args) == 0 {
 			return getVarBinding(env, s.value, frame.args)
-		} else if s.value == "swap" {
+		}
+		//special forms which take their arguments unevaluated
+		switch s.value {
+		case "swap":
 			return swap(env, s.value, frame.args)
+		case "select":
+			return selectStatement(env, s.value, frame.args)
+		case "ns":
+			return nsStatement(env, s.value, frame.args)
+		case "require":
+			return requireStatement(env, s.value, frame.args)
+		case "deftest":
+			return deftest(env, s.value, frame.args)
+		case "testing":
+			return testingStatement(env, s.value, frame.args)
+		case "is":
+			return isStatement(env, s.value, frame.args)
+		case "use-fixtures":
+			return useFixtures(env, s.value, frame.args)
+		case "let", "let*", "letrec":
+			return letStatement(env, s.value, frame.args, allowThunk)
 		}
 		//otherwise assume this is a function call
 		argList, isList := frame.args[0].(SexpPair)
 		if !isList {
-			log.Fatal("Error trying to parse arguments for function call")
+			fatal("Error trying to parse arguments for function call")
 		}
 		//check if this is an anonymous function the macro called
 		if s.value == "fn" {
 			params, isArray := argList.head.(SexpArray)
 			if !isArray {
-				log.Fatal("Error parsing anonymous function in macro expansion!")
+				fatal("Error parsing anonymous function in macro expansion!")
 			}
 			bodyFunc, isValid := argList.tail.(SexpPair)
 			if !isValid {
-				log.Fatal("Error macroexpanding anon function!")
+				fatal("Error macroexpanding anon function!")
 			}
 			anonFunc := SexpFunctionLiteral{name: "fn", arguments: params, body: bodyFunc.head, userfunc: nil, macro: false}
 			return anonFunc
@@ -142,7 +350,7 @@ func (s SexpSymbol) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
 		return funcCall.Eval(env, frame, allowThunk)
 	default:
 		fmt.Println(s.ofType, " ", s.value, " args: ", frame.args)
-		log.Fatal("Uh oh, weird symbol my dude")
+		fatal("Uh oh, weird symbol my dude")
 		return nil
 	}
 }
@@ -157,7 +365,13 @@ func (s SexpFunctionLiteral) Eval(env *Env, frame *StackFrame, allowThunk bool)
 }
 
 func (s SexpFunctionCall) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
-	//each call should get its own environment for recursion to work
+	functionCallEnv := newFunctionEnv(env)
+	dec(env)
+	return evalFunc(functionCallEnv, &s, allowThunk)
+}
+
+//each call should get its own environment for recursion to work
+func newFunctionEnv(env *Env) *Env {
 	functionCallEnv := new(Env)
 	//copy store for speed, otherwise keep recursing to parents
 	functionCallEnv.store = make(map[string]Value)
@@ -166,8 +380,25 @@ func (s SexpFunctionCall) Eval(env *Env, frame *StackFrame, allowThunk bool) Sex
 	}
 	functionCallEnv.steps = env.steps
 	functionCallEnv.parent = env
-	dec(env)
-	return evalFunc(functionCallEnv, &s, allowThunk)
+	functionCallEnv.ports = env.ports
+	functionCallEnv.modules = env.modules
+	functionCallEnv.tests = env.tests
+	functionCallEnv.debugger = env.debugger
+	functionCallEnv.profiler = env.profiler
+	functionCallEnv.trace = env.trace
+	return functionCallEnv
+}
+
+//the environment a function from a module runs in, a copy of its module's (home's) store but with the streams,
+//tracer, profiler, debugger and step limit of the code calling it
+func newHomeEnv(home *Env, caller *Env) *Env {
+	homeEnv := newFunctionEnv(home)
+	homeEnv.steps = caller.steps
+	homeEnv.ports = caller.ports
+	homeEnv.debugger = caller.debugger
+	homeEnv.profiler = caller.profiler
+	homeEnv.trace = caller.trace
+	return homeEnv
 }
 
 func (n SexpPair) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
@@ -181,21 +412,21 @@ func (n SexpPair) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
 	case SexpSymbol:
 		symbol, ok := n.head.(SexpSymbol)
 		if !ok {
-			log.Fatal("error trying to interpret symbol")
+			fatal("error trying to interpret symbol")
 		}
 		arguments := make([]Sexp, 0)
 		//process all arguments here for ease?
 		switch symbol.ofType {
 		case DEFINE:
 			if !isTail {
-				log.Fatal("Unexpected definition, missing value!")
+				fatal("Unexpected definition, missing value!")
 			}
 			newFrame := StackFrame{args: makeList(tail)}
 			//binding to a variable
 			toReturn = symbol.Eval(env, &newFrame, allowThunk)
 		case QUOTE:
 			if !isTail {
-				log.Fatal("Error trying to interpret quote")
+				fatal("Error trying to interpret quote")
 			}
 			//don't evaluate the expression
 			toReturn = tail.head
@@ -207,7 +438,7 @@ func (n SexpPair) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
 			arguments = append(arguments, tail.head.Eval(env, frame, false))
 			statements, isValid := tail.tail.(SexpPair)
 			if !isValid {
-				log.Fatal("Error please provide valid responses to the if condition!")
+				fatal("Error please provide valid responses to the if condition!")
 			}
 			res := makeList(statements)
 			arguments = append(arguments, res...)
@@ -217,7 +448,7 @@ func (n SexpPair) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
 			//if symbol is do, we just evaluate the nodes and return the (result of the) last node
 			//note do's second element will be a list of lists so we need to unwrap it
 			if !isTail {
-				log.Fatal("Error trying to interpret do statements")
+				fatal("Error trying to interpret do statements")
 			}
 			for {
 				//need to set allowThunk to true only if this is the last expression to execute in the do statement
@@ -245,7 +476,7 @@ func (n SexpPair) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
 			head.Eval(env, frame, allowThunk)
 			//check tail != nil for anon function with no parameters
 			if !isTail && n.tail != nil {
-				log.Fatal("Error interpreting anonymous function parameters")
+				fatal("Error interpreting anonymous function parameters")
 			}
 			funcCall := SexpFunctionCall{name: "fn", arguments: tail, body: nil}
 			toReturn = funcCall.Eval(env, frame, allowThunk)
@@ -254,7 +485,7 @@ func (n SexpPair) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
 			//in a function literal, body should only be on Sexp, if there is more, throw an error
 			//in a function call, arguments will be pased into SexpFunctionCall so similar idea
 			if n.tail != nil {
-				log.Fatal("Error interpreting function declaration or literal - ensure only one Sexp in body of function literal!")
+				fatal("Error interpreting function declaration or literal - ensure only one Sexp in body of function literal!")
 			}
 		}
 	case SexpFunctionCall:
@@ -277,7 +508,7 @@ func (n SexpPair) Eval(env *Env, frame *StackFrame, allowThunk bool) Sexp {
 				// 	toReturn = n
 				// } else {
 				// 	fmt.Println(n.head)
-				// 	log.Fatal("Error parsing")
+				// 	fatal("Error parsing")
 				// }
 
 			}
@@ -316,32 +547,89 @@ func dec(env *Env) {
 	if env.steps != maxSteps {
 		env.steps -= 1
 		if env.steps < 0 {
-			log.Fatal("Reached maximum recursion depth :(")
+			fatal("Reached maximum recursion depth :(")
 		}
 	}
 }
 
-//evaluates and interprets our AST
+//RuntimeError is raised when evaluating a Lispy program fails, e.g. calling a function with the wrong arguments
+type RuntimeError struct {
+	Msg string
+	//set when evaluation hit a bug in the interpreter itself (a Go runtime error) rather than a mistake in the program
+	Internal bool
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Msg
+}
+
+//aborts evaluation with a RuntimeError, which Eval reports (and exits) with, and TryEval returns
+func fatal(v ...interface{}) {
+	panic(&RuntimeError{Msg: fmt.Sprint(v...)})
+}
+
+//turns a panic raised by fatal into an error, a Go runtime error is a bug in the interpreter so it is marked as internal
+//(so e.g. a repl can carry on but tests can tell it apart from a mistake in the program), and any other panic is a
+//bug outside of the interpreter so it is passed on
+func recoverError(r interface{}) error {
+	switch err := r.(type) {
+	case *RuntimeError:
+		return err
+	case runtime.Error:
+		return &RuntimeError{Msg: "Internal error in the interpreter, " + err.Error(), Internal: true}
+	}
+	panic(r)
+}
+
+//evaluates and interprets our AST, exiting if evaluation fails
 func (env *Env) Eval(nodes []Sexp) []string {
+	res, err := env.TryEval(nodes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return res
+}
+
+//TryEval evaluates our AST like Eval, but returns the results evaluated before an error along with the error
+//instead of exiting, so e.g. a repl can report it and carry on
+func (env *Env) TryEval(nodes []Sexp) ([]string, error) {
 	res := make([]string, 0)
-	frame := StackFrame{}
-	for _, node := range nodes {
-		curr := node.Eval(env, &frame, false)
-		if curr != nil {
-			// fmt.Println("node: ", node, " with result: ", reflect.TypeOf(curr))
-			res = append(res, curr.String())
+	err := env.try(func() {
+		frame := StackFrame{}
+		for _, node := range nodes {
+			curr := node.Eval(env, &frame, false)
+			if curr != nil {
+				// fmt.Println("node: ", node, " with result: ", reflect.TypeOf(curr))
+				res = append(res, curr.String())
+			}
 		}
+	})
+	return res, err
+}
+
+//runs f, returning any error raised while evaluating instead of exiting
+func (env *Env) try(f func()) (err error) {
+	trace := env.trace
+	var depth int
+	if trace != nil {
+		depth = trace.depth
 	}
-	return res
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverError(r)
+			//traced calls which failed part way through never logged their result
+			if trace != nil {
+				trace.depth = depth
+			}
+		}
+	}()
+	f()
+	return nil
 }
 
 func evalHelper(source string) ([]Sexp, error) {
 	tokens := Read(strings.NewReader(source))
-	ast, err := Parse(tokens)
-	if err != nil {
-		return nil, errors.New("Error parsing source!")
-	}
-	return ast, nil
+	return Parse(tokens)
 }
 
 //method which exposes eval to other packages which call this as an API to get a result
@@ -353,14 +641,14 @@ func EvalSource(source string) ([]string, error) {
 	env := InitState()
 	//limit size of stack / number of steps for safety
 	env.steps = 7000
-	return env.Eval(ast), nil
+	return env.TryEval(ast)
 }
 
 //used to load library packages into the env
 func EvalSourceIO(source string, env *Env) error {
 	ast, err := evalHelper(source)
 	if err != nil {
-		return errors.New("Error parsing source!")
+		return err
 	}
 	env.Eval(ast)
 	return nil
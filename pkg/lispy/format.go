@@ -0,0 +1,169 @@
+package lispy
+
+import (
+	"strings"
+)
+
+//the formatter works on a concrete syntax tree built from ReadRaw, which unlike the AST from Parse still has the
+//comments, the original spelling of every token and the line each one was written on
+
+//fmtNode is a single token (or comment), or a list or array along with everything inside it
+type fmtNode struct {
+	tok Token
+	//' or @ written directly before the node
+	prefix string
+	//set for lists and arrays
+	open     string
+	close    string
+	children []*fmtNode
+	//lines the node starts and ends on in the source
+	line    int
+	endLine int
+}
+
+func (n *fmtNode) isList() bool {
+	return n.open != ""
+}
+
+//line comments run to the end of the line so nothing else can follow them on it
+func (n *fmtNode) isLineComment() bool {
+	return n.tok.Token == COMMENT && !strings.HasPrefix(n.tok.Literal, ";;")
+}
+
+//Format returns source laid out in the canonical style: the line breaks between elements are kept, but every line is
+//indented from the structure of the code, whitespace within a line is normalized and closing brackets are moved
+//to the end of the last element they close
+//source must parse, otherwise the ParseError is returned
+func Format(source string) (string, error) {
+	if _, err := Parse(Read(strings.NewReader(source))); err != nil {
+		return "", err
+	}
+	nodes := buildFmtNodes(ReadRaw(strings.NewReader(source)))
+	var sb strings.Builder
+	for i, node := range nodes {
+		if i > 0 {
+			prev := nodes[i-1]
+			switch {
+			case node.line == prev.endLine && !prev.isLineComment():
+				//e.g. a comment after a definition on the same line
+				sb.WriteString(" ")
+			case node.line > prev.endLine+1:
+				//keep (at most one) blank line between top-level forms
+				sb.WriteString("\n\n")
+			default:
+				sb.WriteString("\n")
+			}
+		}
+		formatNode(&sb, node)
+	}
+	if sb.Len() > 0 {
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+//builds the tree for every top-level form in tokens
+func buildFmtNodes(tokens []Token) []*fmtNode {
+	nodes := make([]*fmtNode, 0)
+	for idx := 0; idx < len(tokens); {
+		node, next := buildFmtNode(tokens, idx)
+		nodes = append(nodes, node)
+		idx = next
+	}
+	return nodes
+}
+
+//builds the node starting at tokens[idx], returning it and the index of the token after it
+//the source has already been parsed so brackets are known to be balanced
+func buildFmtNode(tokens []Token, idx int) (*fmtNode, int) {
+	tok := tokens[idx]
+	node := &fmtNode{tok: tok, line: tok.Line, endLine: tok.Line + strings.Count(tok.Literal, "\n")}
+	switch tok.Token {
+	case QUOTE, DEREF:
+		if idx+1 >= len(tokens) {
+			return node, idx + 1
+		}
+		next, after := buildFmtNode(tokens, idx+1)
+		next.prefix = tok.Literal + next.prefix
+		next.line = tok.Line
+		return next, after
+	case LPAREN, LSQUARE:
+		node.open = tok.Literal
+		node.close = ")"
+		if tok.Token == LSQUARE {
+			node.close = "]"
+		}
+		idx++
+		for idx < len(tokens) && tokens[idx].Token != RPAREN && tokens[idx].Token != RSQUARE {
+			var child *fmtNode
+			child, idx = buildFmtNode(tokens, idx)
+			node.children = append(node.children, child)
+		}
+		if idx < len(tokens) {
+			node.endLine = tokens[idx].Line
+		}
+		return node, idx + 1
+	}
+	return node, idx + 1
+}
+
+//writes node to sb, indenting any lines it is broken over relative to the column it starts at
+func formatNode(sb *strings.Builder, node *fmtNode) {
+	sb.WriteString(node.prefix)
+	if !node.isList() {
+		sb.WriteString(node.tok.Literal)
+		return
+	}
+	col := currentColumn(sb)
+	sb.WriteString(node.open)
+	indent := childIndent(node, col)
+	for i, child := range node.children {
+		if i > 0 {
+			prev := node.children[i-1]
+			if child.line > prev.endLine || prev.isLineComment() {
+				if child.line > prev.endLine+1 {
+					sb.WriteString("\n")
+				}
+				sb.WriteString("\n" + strings.Repeat(" ", indent))
+			} else {
+				sb.WriteString(" ")
+			}
+		}
+		formatNode(sb, child)
+	}
+	if len(node.children) > 0 && node.children[len(node.children)-1].isLineComment() {
+		//the closing bracket would be commented out if it stayed on the comment's line
+		sb.WriteString("\n" + strings.Repeat(" ", indent))
+	}
+	sb.WriteString(node.close)
+}
+
+//returns the column the elements of a list which start on a new line are indented to, col is that of its opening bracket
+//  - the body of forms like define, fn, if, do, let, cond and macro is indented by two spaces
+//  - the arguments of a function call line up with the first argument if it's on the same line as the function
+//  - the elements of data (arrays and lists which don't start with a symbol) line up with the first element
+func childIndent(node *fmtNode, col int) int {
+	if node.open == "[" || len(node.children) == 0 {
+		return col + 1
+	}
+	head := node.children[0]
+	if head.isList() || head.prefix != "" || !isSymbolToken(head.tok.Token) {
+		return col + 1
+	}
+	if _, isBodyForm := bodyForms[head.tok.Literal]; isBodyForm {
+		return col + 2
+	}
+	if len(node.children) > 1 && node.children[1].line == head.endLine && !node.children[1].isLineComment() {
+		return col + 1 + len(head.tok.Literal) + 1
+	}
+	return col + 2
+}
+
+//tokens which name something that can be called
+func isSymbolToken(token TokenType) bool {
+	switch token {
+	case SYMBOL, DEFINE, MACRO, IF, DO:
+		return true
+	}
+	return false
+}
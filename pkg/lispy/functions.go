@@ -1,14 +1,13 @@
 package lispy
 
 import (
-	"bufio"
 	"fmt"
-	"log"
 	"math"
 	"math/rand"
-	"os"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,6 +16,8 @@ type LispyUserFunction func(env *Env, name string, args []Sexp) Sexp
 type FunctionThunkValue struct {
 	env      *Env
 	function FunctionValue
+	//set for a traced call, whose result is logged once the thunk is unwound
+	traced bool
 }
 
 func (thunk FunctionThunkValue) String() string {
@@ -56,7 +57,7 @@ func getVarBinding(env *Env, key string, args []Sexp) Sexp {
 	} else if env.parent != nil {
 		return getVarBinding(env.parent, key, args)
 	}
-	log.Fatal("Error, ", key, " has not previously been defined!")
+	fatal("Error, ", key, " has not previously been defined!")
 	return nil
 }
 
@@ -67,6 +68,12 @@ func (funcVal FunctionValue) Eval(env *Env, frame *StackFrame, allowThunk bool)
 	env.store[name] = funcVal
 	dec(env)
 	list := []Sexp{SexpSymbol{ofType: STRING, value: funcVal.defn.name}, funcVal.defn.arguments, funcVal.defn.body}
+	if len(funcVal.defn.arities) > 0 {
+		list = []Sexp{SexpSymbol{ofType: STRING, value: funcVal.defn.name}}
+		for _, clause := range funcVal.defn.arities {
+			list = append(list, makeSList([]Sexp{clause.arguments, clause.body}))
+		}
+	}
 	return makeSList(list)
 }
 
@@ -77,7 +84,7 @@ func evalFunc(env *Env, s *SexpFunctionCall, allowThunk bool) Sexp {
 		//check if this is a reference to another function / variable
 		node, isFuncLiteral = getVarBinding(env, name, []Sexp{}).(FunctionValue)
 		if !isFuncLiteral {
-			log.Fatal("Error, badly defined function trying to be called: ", s.name)
+			fatal("Error, badly defined function trying to be called: ", s.name)
 		}
 	}
 	//note quite critically, we need to evaluate the result of any expression arguments BEFORE we set them
@@ -96,12 +103,10 @@ func evalFunc(env *Env, s *SexpFunctionCall, allowThunk bool) Sexp {
 				}
 			}
 		}
-		//pass the args directly, macro takes in one input so we can do this directly
-		env.store[node.defn.arguments.value[0].String()] = macroArgs
-		// fmt.Println("macro args => ", node.defn.body)
-		macroRes := node.defn.body.Eval(env, &StackFrame{}, false)
-		//uncomment line below to see macro-expansion
-		// fmt.Println("macro => ", macroRes)
+		macroRes := expandMacro(env, node, macroArgs)
+		if env.trace != nil && env.trace.traces(name) {
+			env.trace.expansion(env, name, s.arguments, macroRes)
+		}
 		finalRes := macroRes.Eval(env, &StackFrame{}, allowThunk)
 		// fmt.Println(name, " res => ", finalRes)
 		//evaluate the result of the macro transformed input
@@ -127,25 +132,35 @@ func evalFunc(env *Env, s *SexpFunctionCall, allowThunk bool) Sexp {
 		}
 
 	}
-	variableNumberOfArgs := false
-	//load the passed in data to the arguments of the function in the environment
-	for i, arg := range node.defn.arguments.value {
-		//if arg has &, means it takes variable number of arguments, so create list of cons cells and set it to name pointing to variable arg
-		if arg.String() == "&" {
-			env.store[node.defn.arguments.value[i+1].String()] = makeSList(newExprs[i:])
-			variableNumberOfArgs = true
-			break
-		} else {
-			env.store[arg.String()] = newExprs[i]
-		}
+	//functions from a module run in (a copy of) the module's environment instead of the caller's
+	if node.home != nil {
+		env = newHomeEnv(node.home, env)
 	}
+	if env.trace != nil && env.trace.traces(name) {
+		return env.trace.call(env, node, name, newExprs, allowThunk)
+	}
+	return applyFunction(env, node, name, newExprs, allowThunk)
+}
+
+//returns the code a macro call expands to, which still needs to be evaluated in env
+func expandMacro(env *Env, node FunctionValue, macroArgs SexpPair) Sexp {
+	//macros from a module are expanded in the module's environment, but the expansion runs in the caller's
+	expandEnv := env
+	if node.home != nil {
+		expandEnv = newHomeEnv(node.home, env)
+	}
+	//pass the args directly, macro takes in one input so we can do this directly
+	expandEnv.store[node.defn.arguments.value[0].String()] = macroArgs
+	// fmt.Println("macro args => ", node.defn.body)
+	return node.defn.body.Eval(expandEnv, &StackFrame{}, false)
+}
 
-	//check we have the correct number of parameters
-	//only do this if not a macro or a built-in function (most of which take a variable number of args and handle invalid ones
-	//internally)
-	if len(node.defn.arguments.value) != len(newExprs) && node.defn.userfunc == nil && !variableNumberOfArgs {
-		fmt.Println(len(node.defn.arguments.value), newExprs)
-		log.Fatal("Incorrect number of arguments passed in to ", node.defn.name)
+//binds already evaluated arguments to the parameters of the function in env and runs it
+func applyFunction(env *Env, node FunctionValue, name string, newExprs []Sexp, allowThunk bool) Sexp {
+	//native builtins (most of which take a variable number of args) check their own arguments
+	if node.defn.userfunc == nil {
+		node = node.withArity(name, len(newExprs))
+		bindParams(env, node.defn, newExprs)
 	}
 
 	//Call LispyUserFunction if this is a builtin function
@@ -166,15 +181,44 @@ func evalFunc(env *Env, s *SexpFunctionCall, allowThunk bool) Sexp {
 	return unwrapThunks(functionThunk)
 }
 
+//calls a function value with arguments that have already been evaluated, used by builtins which take functions
+func callFunction(env *Env, node FunctionValue, args []Sexp) Sexp {
+	if node.defn.macro {
+		fatal("Error, cannot call macro ", node.defn.name, " as a function")
+	}
+	callEnv := newFunctionEnv(env)
+	if node.home != nil {
+		callEnv = newHomeEnv(node.home, env)
+	}
+	return applyFunction(callEnv, node, node.defn.name, args, false)
+}
+
 //unwrap nested function calls into flat for loop structure for tail call optimization
 func unwrapThunks(functionThunk FunctionThunkValue) Sexp {
 	isTail := true
 	var funcResult Sexp
+	//traced calls returning the result, logged once it's known
+	var env *Env
+	traced := 0
 	for isTail {
-		funcResult = functionThunk.function.defn.body.Eval(functionThunk.env, &StackFrame{}, true)
+		if functionThunk.traced {
+			env = functionThunk.env
+			traced++
+		}
+		switch {
+		case functionThunk.env.profiler != nil:
+			funcResult = functionThunk.env.profiler.call(functionThunk)
+		case functionThunk.env.debugger != nil:
+			funcResult = functionThunk.env.debugger.call(functionThunk)
+		default:
+			funcResult = functionThunk.function.defn.body.Eval(functionThunk.env, &StackFrame{}, true)
+		}
 		functionThunk, isTail = funcResult.(FunctionThunkValue)
 		//fmt.Println("cheeky -> ", isTail, " ", funcResult)
 	}
+	for ; traced > 0; traced-- {
+		env.trace.returned(env, funcResult)
+	}
 	return funcResult
 }
 
@@ -236,7 +280,7 @@ func unwrap(arg Sexp) SexpPair {
 			return listPair
 		default:
 			fmt.Println(reflect.TypeOf(arg), arg)
-			log.Fatal("Error unwrapping for built in functions")
+			fatal("Error unwrapping for built in functions")
 		}
 	}
 	return SexpPair{head: pair1, tail: nil}
@@ -244,7 +288,7 @@ func unwrap(arg Sexp) SexpPair {
 
 func car(env *Env, name string, args []Sexp) Sexp {
 	if len(args) == 0 {
-		log.Fatal("Uh oh, you need to pass an argument to car")
+		fatal("Uh oh, you need to pass an argument to car")
 	}
 	//need to unwrap twice since function call arguments wrap inner arguments in a SexpPair
 	//so we have SexpPair{head: SexpPair{...}}
@@ -261,7 +305,7 @@ func car(env *Env, name string, args []Sexp) Sexp {
 
 func cdr(env *Env, name string, args []Sexp) Sexp {
 	if len(args) == 0 {
-		log.Fatal("Uh oh, you need to pass an argument to car")
+		fatal("Uh oh, you need to pass an argument to car")
 	}
 	pair1 := unwrap(args[0])
 	switch i := pair1.head.(type) {
@@ -278,14 +322,14 @@ func cdr(env *Env, name string, args []Sexp) Sexp {
 		return pair1.tail
 	default:
 		fmt.Println(reflect.TypeOf(i))
-		log.Fatal("argument 0 of cdr has wrong type!")
+		fatal("argument 0 of cdr has wrong type!")
 	}
 	return nil
 }
 
 func cons(env *Env, name string, args []Sexp) Sexp {
 	if len(args) < 2 {
-		log.Fatal("Incorrect number of arguments!")
+		fatal("Incorrect number of arguments!")
 	}
 	//unwrap the list in the block quote (need to evaluate first to allow for recursive calls)
 	list := unwrap(args[1])
@@ -301,7 +345,7 @@ func consHelper(a Sexp, b Sexp) SexpPair {
 /******* quote *********/
 func isQuote(env *Env, name string, args []Sexp) Sexp {
 	if len(args) == 0 {
-		log.Fatal("Error checking quote type")
+		fatal("Error checking quote type")
 	}
 	switch i := args[0].(type) {
 	case SexpSymbol:
@@ -316,16 +360,16 @@ func isQuote(env *Env, name string, args []Sexp) Sexp {
 //note swap only works for lists!
 func swap(env *Env, name string, args []Sexp) Sexp {
 	if len(args) == 0 {
-		log.Fatal("Error trying to swap element")
+		fatal("Error trying to swap element")
 	}
 	//enforce swap only for lists
 	list, isList := args[0].(SexpPair)
 	if !isList {
-		log.Fatal("Error trying to parse arguments of swap")
+		fatal("Error trying to parse arguments of swap")
 	}
 	newList, isNewList := list.tail.(SexpPair)
 	if !isNewList {
-		log.Fatal("Error swapping non-list!")
+		fatal("Error swapping non-list!")
 	}
 	fmt.Println(list.head.String())
 	newVal := newList.head.Eval(env, &StackFrame{}, false)
@@ -347,15 +391,18 @@ func setValWhileKeyExists(env *Env, key string, val Value) {
 //reads one object from a string
 func readstring(env *Env, name string, args []Sexp) Sexp {
 	if len(args) < 1 {
-		log.Fatal("Error trying to read object from tring!")
+		fatal("Error trying to read object from tring!")
 	}
 	stringObj, isString := args[0].(SexpSymbol)
 	if !isString || stringObj.ofType != STRING {
-		log.Fatal("Error trying to read an object from a non-string!")
+		fatal("Error trying to read an object from a non-string!")
 	}
 	res, err := evalHelper(stringObj.value)
 	if err != nil {
-		log.Fatal("Error trying to parse an object from a string !")
+		fatal("Error trying to parse an object from a string !")
+	}
+	if len(res) == 0 {
+		fatal("Error, ", name, " found nothing to read in ", readableString(stringObj))
 	}
 	//readstring only reads first object
 	return res[0]
@@ -364,14 +411,11 @@ func readstring(env *Env, name string, args []Sexp) Sexp {
 /******* readline *********/
 func readline(env *Env, name string, args []Sexp) Sexp {
 	if len(args) > 0 {
-		fmt.Print(args[0].String())
+		fmt.Fprint(env.ports.out, args[0].String())
 	}
-	scanner := bufio.NewScanner(os.Stdin)
-	var val string
-	if scanner.Scan() {
-		val = scanner.Text()
-	}
-
+	//an error here means we hit the end of the input, in which case return whatever was read
+	line, _ := env.ports.in.ReadString('\n')
+	val := strings.TrimRight(line, "\r\n")
 	return SexpSymbol{ofType: STRING, value: val}
 }
 
@@ -392,7 +436,7 @@ func conditionalStatement(env *Env, name string, args []Sexp) Sexp {
 	//TODO: improve this
 	args = args[:len(args)-1]
 	if !isThunk {
-		log.Fatal("Error passing thunk into conditional statement")
+		fatal("Error passing thunk into conditional statement")
 	}
 	allowThunk := thunk.ofType == TRUE
 	var condition bool
@@ -415,7 +459,7 @@ func conditionalStatement(env *Env, name string, args []Sexp) Sexp {
 		}
 	default:
 		fmt.Println(i)
-		log.Fatal("Error trying to interpret condition for if statement")
+		fatal("Error trying to interpret condition for if statement")
 	}
 	if condition {
 		toReturn = args[1].Eval(env, &StackFrame{}, allowThunk)
@@ -433,7 +477,7 @@ func conditionalStatement(env *Env, name string, args []Sexp) Sexp {
 /******* handle random numbers *********/
 func random(env *Env, name string, args []Sexp) Sexp {
 	if len(args) != 0 {
-		log.Fatal("Error generating random number")
+		fatal("Error generating random number")
 	}
 	//generate a random seed, otherwise the same random number will be generated
 	rand.Seed(time.Now().UnixNano())
@@ -443,7 +487,7 @@ func random(env *Env, name string, args []Sexp) Sexp {
 /******* applies function to list of args similar to function applyTo in Clojure *********/
 func applyTo(env *Env, name string, args []Sexp) Sexp {
 	if len(args) < 2 {
-		log.Fatal("Error applying function to args")
+		fatal("Error applying function to args")
 	}
 	functionLiteral, isFuncLiteral := args[0].(FunctionValue)
 	if !isFuncLiteral {
@@ -451,13 +495,13 @@ func applyTo(env *Env, name string, args []Sexp) Sexp {
 			functionLiteral, isFuncLiteral = env.store[args[0].String()].(FunctionValue)
 		}
 		if !isFuncLiteral {
-			log.Fatal("Error trying to apply a value that is not a function")
+			fatal("Error trying to apply a value that is not a function")
 		}
 
 	}
 	arguments, isArgs := args[1].(SexpPair)
 	if !isArgs {
-		log.Fatal("Error applyTo only operates on lists!")
+		fatal("Error applyTo only operates on lists!")
 	}
 	return SexpFunctionCall{name: functionLiteral.defn.name, arguments: arguments}.Eval(env, &StackFrame{}, false)
 }
@@ -465,13 +509,13 @@ func applyTo(env *Env, name string, args []Sexp) Sexp {
 /******* handle type conversions for non-list *********/
 func number(env *Env, name string, args []Sexp) Sexp {
 	if len(args) > 1 {
-		log.Fatal("Error casting to number")
+		fatal("Error casting to number")
 	}
 	switch i := args[0].(type) {
 	case SexpSymbol:
 		num, err := strconv.ParseFloat(i.value, 64)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		return SexpFloat(num)
 	case SexpInt:
@@ -479,27 +523,173 @@ func number(env *Env, name string, args []Sexp) Sexp {
 	case SexpFloat:
 		return i
 	default:
-		log.Fatal("Error casting list to number")
+		fatal("Error casting list to number")
 		return nil
 	}
 }
 
 func symbol(env *Env, name string, args []Sexp) Sexp {
 	if len(args) > 1 {
-		log.Fatal("Error casting to number")
+		fatal("Error casting to number")
 	}
 	return SexpSymbol{ofType: SYMBOL, value: args[0].String()}
 }
 
-/******* handle println statements *********/
+//counts the symbols made by gensym in every interpreter, so no two are the same
+var gensymCount int64
+
+//(gensym) returns a new symbol for macros to bind without capturing any of the caller's names
+func gensym(env *Env, name string, args []Sexp) Sexp {
+	if len(args) != 0 {
+		fatal("Error, ", name, " takes no arguments")
+	}
+	return SexpSymbol{ofType: SYMBOL, value: fmt.Sprint("gensym__", atomic.AddInt64(&gensymCount, 1))}
+}
+
+/******* handle print statements *********/
+//print and println write the display form of their arguments, pr and prn write the readable form
+func printStatement(env *Env, name string, args []Sexp) Sexp {
+	fmt.Fprint(env.ports.out, joinArgs(args, false))
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
+
 func printlnStatement(env *Env, name string, args []Sexp) Sexp {
+	fmt.Fprintln(env.ports.out, joinArgs(args, false))
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
+
+func prStatement(env *Env, name string, args []Sexp) Sexp {
+	fmt.Fprint(env.ports.out, joinArgs(args, true))
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
+
+func prnStatement(env *Env, name string, args []Sexp) Sexp {
+	fmt.Fprintln(env.ports.out, joinArgs(args, true))
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
+
+func printfStatement(env *Env, name string, args []Sexp) Sexp {
+	fmt.Fprint(env.ports.out, formatString(name, args))
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
+
+func format(env *Env, name string, args []Sexp) Sexp {
+	return SexpSymbol{ofType: STRING, value: formatString(name, args)}
+}
+
+//helper function to join arguments with spaces, in either their display or readable form
+func joinArgs(args []Sexp, readable bool) string {
+	vals := make([]string, 0)
 	for _, arg := range args {
-		//uncomment to see live prints for local stuff
-		//fmt.Print(arg.String(), " ")
-		return arg
+		if readable {
+			vals = append(vals, readableString(arg))
+		} else {
+			vals = append(vals, arg.String())
+		}
 	}
-	fmt.Println()
-	return nil
+	return strings.Join(vals, " ")
+}
+
+//formats the arguments using Go-style verbs e.g. (format "%s is %d" "x" 5)
+//numbers are passed through as Go numbers so %d, %f, %.2f etc. work, strings as Go strings so %s and %q work,
+//and everything else (lists, arrays, functions) as its display form
+func formatString(name string, args []Sexp) string {
+	if len(args) == 0 {
+		fatal("Error, ", name, " requires a format string")
+	}
+	formatStr, isString := args[0].(SexpSymbol)
+	if !isString || formatStr.ofType != STRING {
+		fatal("Error, the first argument to ", name, " must be a format string")
+	}
+	vals := make([]interface{}, 0)
+	for _, arg := range args[1:] {
+		switch i := arg.(type) {
+		case SexpInt:
+			vals = append(vals, int(i))
+		case SexpFloat:
+			vals = append(vals, float64(i))
+		case SexpSymbol:
+			vals = append(vals, i.value)
+		default:
+			vals = append(vals, arg.String())
+		}
+	}
+	return fmt.Sprintf(formatStr.value, vals...)
+}
+
+//wraps a string in quotes, escaping the characters the lexer treats specially
+func quoteString(val string) string {
+	replacer := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t")
+	return "\"" + replacer.Replace(val) + "\""
+}
+
+//returns the form of an expression that can be read back in by the reader, i.e. with strings quoted
+func readableString(s Sexp) string {
+	switch i := s.(type) {
+	case SexpSymbol:
+		if i.ofType == STRING {
+			return quoteString(i.value)
+		}
+		return i.value
+	case SexpPair:
+		if i.head == nil {
+			return "()"
+		}
+		vals := make([]string, 0)
+		var pair Sexp = i
+		for {
+			curr, isPair := pair.(SexpPair)
+			if !isPair {
+				//improper list ending in a non-list tail
+				vals = append(vals, ".", readableString(pair))
+				break
+			}
+			if curr.head != nil {
+				vals = append(vals, readableString(curr.head))
+			}
+			if curr.tail == nil {
+				break
+			}
+			pair = curr.tail
+		}
+		return "(" + strings.Join(vals, " ") + ")"
+	case SexpArray:
+		vals := make([]string, 0)
+		for _, node := range i.value {
+			vals = append(vals, readableString(node))
+		}
+		return "[" + strings.Join(vals, " ") + "]"
+	case SexpFunctionLiteral:
+		if i.userfunc == nil || i.body != nil {
+			return functionSource(&i)
+		}
+		return s.String()
+	case nil:
+		return "()"
+	default:
+		return s.String()
+	}
+}
+
+//returns the code defining a function e.g. (define square [x] (* x x)), or (fn [x] (* x x)) for anonymous functions
+func functionSource(defn *SexpFunctionLiteral) string {
+	source := readableString(defn.arguments) + " " + readableString(defn.body)
+	if len(defn.arities) > 0 {
+		clauses := make([]string, 0)
+		for _, clause := range defn.arities {
+			clauses = append(clauses, "("+readableString(clause.arguments)+" "+readableString(clause.body)+")")
+		}
+		source = strings.Join(clauses, " ")
+	}
+	if defn.doc != "" {
+		source = readableString(SexpSymbol{ofType: STRING, value: defn.doc}) + " " + source
+	}
+	if defn.name == "fn" {
+		return "(fn " + source + ")"
+	} else if defn.macro {
+		return "(macro " + defn.name + " " + source + ")"
+	}
+	return "(define " + defn.name + " " + source + ")"
 }
 
 /******* handle logical (and or not) operations *********/
@@ -521,17 +711,17 @@ func not(env *Env, name string, args []Sexp) Sexp {
 func logicalOperator(env *Env, name string, args []Sexp) Sexp {
 	result := getBoolFromTokenType(args[0].Eval(env, &StackFrame{}, false))
 	if len(args) == 0 {
-		log.Fatal("Invalid syntax, pass in more than logical operator!")
+		fatal("Invalid syntax, pass in more than logical operator!")
 	}
 	//not can only take one parameter so check that first
 	if name == "not" {
 		if len(args) > 1 {
-			log.Fatal("Error, cannot pass more than one logical operator to not!")
+			fatal("Error, cannot pass more than one logical operator to not!")
 		}
 		result = handleLogicalOp(name, result)
 	} else {
 		if len(args) < 2 {
-			log.Fatal("Error, cannot carry out an ", name, " operator with only 1 condition!")
+			fatal("Error, cannot carry out an ", name, " operator with only 1 condition!")
 		}
 		//for and, or, loop through the arguments and aggregate
 		for i := 1; i < len(args); i++ {
@@ -585,7 +775,7 @@ func handleLogicalOp(name string, log ...bool) bool {
 func typeOf(env *Env, name string, args []Sexp) Sexp {
 	var typeCurr SexpSymbol
 	if len(args) < 1 {
-		log.Fatal("require a parameter to check type of")
+		fatal("require a parameter to check type of")
 	}
 	switch i := args[0].(type) {
 	case SexpInt:
@@ -602,9 +792,17 @@ func typeOf(env *Env, name string, args []Sexp) Sexp {
 		typeCurr = SexpSymbol{ofType: STRING, value: "symbol"}
 	case SexpFunctionLiteral, SexpFunctionCall:
 		typeCurr = SexpSymbol{ofType: STRING, value: "list"}
+	case SexpChannel:
+		typeCurr = SexpSymbol{ofType: STRING, value: "channel"}
+	case SexpAtom:
+		typeCurr = SexpSymbol{ofType: STRING, value: "atom"}
+	case SexpArray:
+		typeCurr = SexpSymbol{ofType: STRING, value: "array"}
+	case FunctionValue:
+		typeCurr = SexpSymbol{ofType: STRING, value: "function"}
 	default:
 		fmt.Println(i)
-		log.Fatal("unexpected type!")
+		fatal("unexpected type!")
 	}
 	return typeCurr
 }
@@ -654,7 +852,7 @@ func relationalOperator(env *Env, name string, args []Sexp) Sexp {
 			result = relationalOperatorMatchLiteral(name, i, curr)
 		default:
 			fmt.Println(args)
-			log.Fatal("Error, unexpected type in relational operator")
+			fatal("Error, unexpected type in relational operator")
 		}
 		if !result {
 			tokenType = FALSE
@@ -739,7 +937,7 @@ func getBoolFromString(boolean bool) string {
 	case false:
 		res = "false"
 	default:
-		log.Fatal("Error with passed in bool")
+		fatal("Error with passed in bool")
 	}
 	return res
 }
@@ -810,7 +1008,7 @@ func binaryOperation(env *Env, name string, args []Sexp) Sexp {
 	res := args[0]
 	switch i := res.(type) {
 	case SexpArray, SexpPair, SexpFunctionCall, SexpFunctionLiteral:
-		log.Fatal("Invalid type passed to a binary operation!")
+		fatal("Invalid type passed to a binary operation!")
 	case SexpSymbol:
 		if i.value == "" {
 			return binaryOperation(env, name, args[1:])
@@ -824,7 +1022,7 @@ func binaryOperation(env *Env, name string, args []Sexp) Sexp {
 		case SexpInt:
 			res = numericMatchInt(name, term, args[i])
 		default:
-			log.Fatal("Invalid type passed to a binary operation!")
+			fatal("Invalid type passed to a binary operation!")
 
 		}
 	}
@@ -844,7 +1042,7 @@ func numericMatchInt(name string, x SexpInt, y Sexp) Sexp {
 		}
 	default:
 		fmt.Println(y)
-		log.Fatal("Error adding two numbers!")
+		fatal("Error adding two numbers!")
 	}
 	return res
 }
@@ -861,7 +1059,7 @@ func numericMatchFloat(name string, x SexpFloat, y Sexp) Sexp {
 			return x
 		}
 	default:
-		log.Fatal("Error adding two numbers!")
+		fatal("Error adding two numbers!")
 	}
 	return res
 }
@@ -875,7 +1073,7 @@ func numericOpInt(name string, x SexpInt, y SexpInt) Sexp {
 		res = x - y
 	case "/":
 		if y == 0 {
-			log.Fatal("Error attempted division by 0")
+			fatal("Error attempted division by 0")
 		}
 		res = x / y
 	case "*":
@@ -885,7 +1083,7 @@ func numericOpInt(name string, x SexpInt, y SexpInt) Sexp {
 	case "%":
 		res = x % y
 	default:
-		log.Fatal("Error invalid operation")
+		fatal("Error invalid operation")
 	}
 	return res
 
@@ -900,7 +1098,7 @@ func numericOpFloat(name string, x SexpFloat, y SexpFloat) Sexp {
 		res = x - y
 	case "/":
 		if y == 0 {
-			log.Fatal("Error attempted division by 0")
+			fatal("Error attempted division by 0")
 		}
 		res = x / y
 	case "*":
@@ -910,7 +1108,7 @@ func numericOpFloat(name string, x SexpFloat, y SexpFloat) Sexp {
 	case "%":
 		res = SexpInt(int(x) % int(y))
 	default:
-		log.Fatal("Error invalid operation")
+		fatal("Error invalid operation")
 
 	}
 	return res
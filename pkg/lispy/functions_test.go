@@ -0,0 +1,286 @@
+package lispy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+//each case is evaluated in a fresh environment, $TMP in the source is replaced with a temporary directory
+var builtinTests = []struct {
+	builtin string
+	source  string
+	//the result of the last expression
+	want string
+	//what was printed, if anything
+	output string
+	//if set, evaluation should fail with an error containing this
+	err string
+}{
+	{builtin: "car", source: "(car '(1 2 3))", want: "1"},
+	{builtin: "car", source: "(car)", err: "you need to pass an argument to car"},
+	{builtin: "cdr", source: "(cdr '(1 2 3))", want: "(2 3)"},
+	{builtin: "cons", source: "(cons 1 '(2 3))", want: "(1 2 3)"},
+	{builtin: "+", source: "(+ 1 2 3)", want: "6"},
+	{builtin: "+", source: "(+ 1 2.5)", want: "3.500000"},
+	{builtin: "-", source: "(- 10 4 1)", want: "5"},
+	{builtin: "/", source: "(/ 7 2)", want: "3"},
+	{builtin: "/", source: "(/ 7.0 2)", want: "3.500000"},
+	{builtin: "*", source: "(* 2 3 4)", want: "24"},
+	{builtin: "#", source: "(# 2 10)", want: "1024"},
+	{builtin: "%", source: "(% 17 5)", want: "2"},
+	{builtin: "=", source: "(= 2 2)", want: "true"},
+	{builtin: "=", source: `(= "a" "b")`, want: "false"},
+	{builtin: ">=", source: "(>= 3 3)", want: "true"},
+	{builtin: "<=", source: "(<= 4 3)", want: "false"},
+	{builtin: ">", source: "(> 4 3)", want: "true"},
+	{builtin: "<", source: "(< 4 3)", want: "false"},
+	{builtin: "and", source: "(and true false)", want: "false"},
+	{builtin: "or", source: "(or false true)", want: "true"},
+	{builtin: "not", source: "(not false)", want: "true"},
+	{builtin: "print", source: `(print "a" 1)`, output: "a 1"},
+	{builtin: "println", source: `(println "hello")`, output: "hello\n"},
+	{builtin: "pr", source: `(pr "a")`, output: `"a"`},
+	{builtin: "prn", source: `(prn "a" '(1 "b"))`, output: "\"a\" (1 \"b\")\n"},
+	{builtin: "prn", source: `(prn "say \"hi\"\n")`, output: "\"say \\\"hi\\\"\\n\"\n"},
+	{builtin: "printf", source: `(printf "%d-%s" 1 "x")`, output: "1-x"},
+	{builtin: "printf", source: `(printf "%s\t%d\n" "tab" 2)`, output: "tab\t2\n"},
+	{builtin: "pprint", source: "(pprint '(1 2))", output: "(1 2)\n"},
+	{builtin: "assert=", source: "(assert= 1 1)", want: "true"},
+	{builtin: "assert=", source: "(assert= 1 2)", want: "false", output: "FAIL (assert= 1 2)\n"},
+	{builtin: "break", source: "(break)", want: "nil"},
+	{builtin: "trace", source: "(define f [x] (inc x)) (trace f) (f 1)", want: "2", output: "(f 1)\n=> 2\n"},
+	{builtin: "trace", source: "(define f [x] (inc x)) (trace 'f 'inc) (f 1)", want: "2", output: "(f 1)\n  (inc 1)\n  => 2\n=> 2\n"},
+	{builtin: "untrace", source: "(define f [x] (inc x)) (trace f) (untrace f) (f 1)", want: "2"},
+	{builtin: "doc", source: "(doc car)", want: "nil", output: "(car list)\n  native builtin\n  Returns the first element of list.\n"},
+	{builtin: "doc", source: `(define sq "Squares x." [x] (* x x)) (doc 'sq)`, want: "nil", output: "(sq x)\n  function\n  Squares x.\n"},
+	{builtin: "doc", source: "(doc 5)", err: "expects a function or the name of one"},
+	{builtin: "format", source: `(format "%d/%d" 1 2)`, want: "1/2"},
+	{builtin: "list", source: "(list 1 (+ 1 1) 3)", want: "(1 2 3)"},
+	{builtin: "type", source: "(type 1)", want: "int"},
+	{builtin: "type", source: `(type "s")`, want: "symbol"},
+	{builtin: "type", source: "(type '(1 2))", want: "list"},
+	{builtin: "quote", source: "(quote (a b))", want: "(a b)"},
+	{builtin: "rand", source: "(< (rand) 1)", want: "true"},
+	{builtin: "number", source: `(number "41")`, want: "41.000000"},
+	{builtin: "symbol", source: `(symbol "abc")`, want: "abc"},
+	{builtin: "gensym", source: "(= (gensym) (gensym))", want: "false"},
+	{builtin: "gensym", source: "(gensym 1)", err: "gensym takes no arguments"},
+	{builtin: "readline", source: "(readline)", want: "typed input"},
+	{builtin: "str", source: `(str "a" 1 "b")`, want: "a1b"},
+	{builtin: "quote?", source: "(quote? (car ''a))", want: "true"},
+	{builtin: "quote?", source: "(quote? 'a)", want: "false"},
+	{builtin: "applyTo", source: "(applyTo + '(1 2 3))", want: "6"},
+	{builtin: "readstring", source: `(readstring "(+ 1 2)")`, want: "(+ 1 2)"},
+	{builtin: "readstring", source: `(readstring "  ")`, err: "readstring found nothing to read in \"  \""},
+	{builtin: "spawn", source: "(recv! (spawn + 1 2))", want: "3"},
+	{builtin: "go", source: "(recv! (go (fn [] 5)))", want: "5"},
+	{builtin: "chan", source: "(type (chan 1))", want: "channel"},
+	{builtin: "send!", source: "(define c (chan 1)) (send! c 7) (recv! c)", want: "7"},
+	{builtin: "recv!", source: "(define c (chan 1)) (close! c) (recv! c)", want: "nil"},
+	{builtin: "close!", source: "(define c (chan)) (close! c) (send! c 1)", err: "closed"},
+	{builtin: "atom", source: "(type (atom 1))", want: "atom"},
+	{builtin: "deref", source: "(define a (atom 1)) @a", want: "1"},
+	{builtin: "reset!", source: "(define a (atom 1)) (reset! a 5) (deref a)", want: "5"},
+	{builtin: "swap!", source: "(define a (atom 1)) (swap! a + 10)", want: "11"},
+	{builtin: "compare-and-set!", source: "(define a (atom 1)) (compare-and-set! a 1 2) (deref a)", want: "2"},
+	{builtin: "compare-and-set!", source: "(define a (atom 1)) (compare-and-set! a 3 2)", want: "false"},
+	{builtin: "add-watch", source: "(define a (atom 1)) (add-watch a 'w (fn [k r old new] (println old new))) (reset! a 2)", output: "1 2\n", want: "2"},
+	{builtin: "remove-watch", source: "(define a (atom 1)) (add-watch a 'w (fn [k r old new] (println old new))) (remove-watch a 'w) (reset! a 2)", want: "2"},
+	{builtin: "save-image", source: `(define x 42) (save-image "$TMP/image.json")`, want: "$TMP/image.json"},
+	{builtin: "load-image", source: `(define x 42) (save-image "$TMP/image.json") (define x 0) (load-image "$TMP/image.json") x`, want: "42"},
+}
+
+func TestBuiltins(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range builtinTests {
+		source := strings.ReplaceAll(test.source, "$TMP", filepath.ToSlash(dir))
+		want := strings.ReplaceAll(test.want, "$TMP", filepath.ToSlash(dir))
+		exprs, err := Parse(Read(strings.NewReader(source)))
+		if err != nil {
+			t.Errorf("%s: parsing %s failed: %v", test.builtin, test.source, err)
+			continue
+		}
+		var out bytes.Buffer
+		env := InitState()
+		env.SetOutput(&out)
+		env.SetError(&out)
+		env.SetInput(strings.NewReader("typed input\n"))
+		res, err := env.TryEval(exprs)
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: %s failed with %v, expected an error containing %q", test.builtin, test.source, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%s: %s failed: %v", test.builtin, test.source, err)
+		case want != "" && (len(res) == 0 || res[len(res)-1] != want):
+			t.Errorf("%s: %s = %v, expected %s", test.builtin, test.source, res, want)
+		case out.String() != test.output:
+			t.Errorf("%s: %s printed %q, expected %q", test.builtin, test.source, out.String(), test.output)
+		}
+	}
+}
+
+//makes sure a new builtin doesn't go untested
+func TestEveryBuiltinIsTested(t *testing.T) {
+	tested := make(map[string]bool)
+	for _, test := range builtinTests {
+		tested[test.builtin] = true
+	}
+	for name := range returnDefinedFunctions() {
+		if !tested[name] {
+			t.Errorf("no test for the builtin %s in builtinTests", name)
+		}
+	}
+}
+
+//makes sure a new builtin doesn't go undocumented
+func TestEveryBuiltinIsDocumented(t *testing.T) {
+	docs := returnBuiltinDocs()
+	for name := range returnDefinedFunctions() {
+		if docs[name].params == "" || docs[name].doc == "" {
+			t.Errorf("no documentation for the builtin %s in returnBuiltinDocs", name)
+		}
+	}
+}
+
+//the prelude has to work in an environment with only the pure builtins
+func TestPurePrelude(t *testing.T) {
+	env := InitState(CapPure)
+	for source, want := range map[string]string{
+		`(switch 2 (1 "a") (2 "b"))`: "b",
+		"(= (gensym) (gensym))":       "false",
+		"(rand)":                      "not permitted",
+	} {
+		exprs, err := Parse(Read(strings.NewReader(source)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := env.TryEval(exprs)
+		if err != nil {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s failed with %v, expected %s", source, err, want)
+			}
+		} else if len(res) == 0 || res[len(res)-1] != want {
+			t.Errorf("%s = %v, expected %s", source, res, want)
+		}
+	}
+}
+
+//goroutines requiring a module another one is still loading wait for it, rather than seeing a circular require
+func TestConcurrentRequire(t *testing.T) {
+	dir := t.TempDir()
+	slow := "(define spin [n] (if (= n 0) 0 (spin (- n 1))))\n(spin 2000)\n(define answer 42)\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "slow.lpy"), []byte(slow), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exprs, err := Parse(Read(strings.NewReader(`(define workers (map (list 1 2 3 4) (fn [_] (spawn (fn [] (do (require 'slow) slow/answer))))))
+(map workers recv!)`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errors bytes.Buffer
+	env := InitState()
+	env.SetError(&errors)
+	env.SetModulePath(dir)
+	res, err := env.TryEval(exprs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res[len(res)-1]; got != "(42 42 42 42)" || errors.Len() != 0 {
+		t.Errorf("got %s with errors %q, expected every goroutine to get (42 42 42 42)", got, errors.String())
+	}
+}
+
+//if the prelude fails to load, every environment created afterwards reports it rather than just the first
+func TestPreludeError(t *testing.T) {
+	files := prelude
+	defer func() {
+		prelude, preludeOnce, preludeStore, preludeErr = files, sync.Once{}, nil, nil
+	}()
+	prelude, preludeOnce, preludeStore = append(append([]string{}, files...), "missing.lpy"), sync.Once{}, nil
+	for i := 0; i < 2; i++ {
+		func() {
+			defer func() {
+				err, isErr := recover().(*RuntimeError)
+				if !isErr || !strings.Contains(err.Error(), "missing.lpy") {
+					t.Errorf("call %d: expected the error loading missing.lpy, got %v", i+1, err)
+				}
+			}()
+			InitState()
+		}()
+	}
+}
+
+//an error in a required module is returned to the code requiring it, and a later require tries to load it again
+func TestRequireError(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.lpy"), []byte("(define x (car))\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exprs, err := Parse(Read(strings.NewReader("(require 'bad) bad/x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := InitState()
+	env.SetModulePath(dir)
+	if _, err := env.TryEval(exprs); err == nil || !strings.Contains(err.Error(), "Error loading module bad") {
+		t.Fatalf("expected an error loading bad, got %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.lpy"), []byte("(define x 1)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := env.TryEval(exprs)
+	if err != nil || res[len(res)-1] != "1" {
+		t.Errorf("expected bad/x to be 1 once the module was fixed, got %v with %v", res, err)
+	}
+}
+
+//the prelude holds no state, so nothing leaks between interpreters or is exported by modules and images
+func TestPreludeHasNoAtoms(t *testing.T) {
+	for name, val := range InitState().store {
+		if _, isAtom := val.(SexpAtom); isAtom {
+			t.Errorf("the prelude binds %s to an atom", name)
+		}
+	}
+}
+
+//an error evaluating a prelude is returned by LoadPrelude rather than exiting
+func TestLoadPreludeError(t *testing.T) {
+	env := InitState()
+	err := env.LoadPrelude(fstest.MapFS{"bad.lpy": {Data: []byte("(define x (car))\n")}})
+	if err == nil || !strings.Contains(err.Error(), "car") {
+		t.Errorf("expected the error from car, got %v", err)
+	}
+}
+
+//loading an image with a function from a module which can't be found returns an error rather than panicking
+func TestLoadImageMissingModule(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "util.lpy"), []byte("(define twice [x] (* 2 x))\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exprs, err := Parse(Read(strings.NewReader("(require 'util :refer [twice])")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := InitState()
+	env.SetModulePath(dir)
+	if _, err := env.TryEval(exprs); err != nil {
+		t.Fatal(err)
+	}
+	var img bytes.Buffer
+	if err := env.SaveImage(&img); err != nil {
+		t.Fatal(err)
+	}
+	fresh := InitState()
+	fresh.SetModulePath(t.TempDir())
+	if err := fresh.LoadImage(&img); err == nil || !strings.Contains(err.Error(), "could not find module util") {
+		t.Errorf("expected an error finding util, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package lispy
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//rewrites the golden files with the current output instead of comparing against it:
+//go test ./pkg/lispy -run TestGolden -update
+var update = flag.Bool("update", false, "rewrite the golden .out files in tests/ with the current output")
+
+const goldenDir = "../../tests"
+
+//files which take too long to run with -short
+var slowGolden = map[string]bool{"test4.lpy": true}
+
+//runs every tests/*.lpy file and compares what it printed, along with the result of each top-level expression, with
+//the .out file next to it. If there is a .in file next to it, it's used as the input for builtins like readline
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(goldenDir, "*.lpy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no .lpy files found in ", goldenDir)
+	}
+	for _, file := range files {
+		file := file
+		name := filepath.Base(file)
+		t.Run(strings.TrimSuffix(name, ".lpy"), func(t *testing.T) {
+			if testing.Short() && slowGolden[name] {
+				t.Skip("skipping slow file with -short")
+			}
+			got := runGolden(t, file)
+			golden := strings.TrimSuffix(file, ".lpy") + ".out"
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if got != string(want) {
+				t.Errorf("output of %s doesn't match %s, %s\nrun with -update if the change is intended", name, golden, firstDifference(string(want), got))
+			}
+		})
+	}
+}
+
+//evaluates file in a fresh environment, returning everything it printed with the result of each top-level expression
+//written after its output. Evaluation stops at the first error, which is written as error: message
+func runGolden(t *testing.T, file string) string {
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	env := InitState()
+	env.SetOutput(&out)
+	env.SetError(&out)
+	env.AddModulePath(filepath.Dir(file))
+	input, err := ioutil.ReadFile(strings.TrimSuffix(file, ".lpy") + ".in")
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	env.SetInput(bytes.NewReader(input))
+	exprs, err := Parse(Read(bytes.NewReader(source)))
+	if err != nil {
+		fmt.Fprintln(&out, "parse error:", err)
+		return out.String()
+	}
+	for _, expr := range exprs {
+		res, err := env.TryEval([]Sexp{expr})
+		for _, line := range res {
+			fmt.Fprintln(&out, line)
+		}
+		if err != nil {
+			//a golden file should never lock in a bug in the interpreter
+			if runtimeErr, isRuntimeErr := err.(*RuntimeError); isRuntimeErr && runtimeErr.Internal {
+				t.Errorf("%s hit an internal error: %v", file, err)
+			}
+			fmt.Fprintln(&out, "error:", err)
+			break
+		}
+	}
+	return out.String()
+}
+
+//describes the first line where got differs from want
+func firstDifference(want string, got string) string {
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g || i >= len(wantLines) || i >= len(gotLines) {
+			return fmt.Sprintf("line %d is %q, expected %q", i+1, g, w)
+		}
+	}
+	return "they differ"
+}
+
+//the standard library and the golden files are kept formatted, like lispy fmt -check lib tests
+func TestFormatted(t *testing.T) {
+	for _, pattern := range []string{"../../lib/*.lpy", filepath.Join(goldenDir, "*.lpy")} {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			source, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			formatted, err := Format(string(source))
+			if err != nil {
+				t.Errorf("%s: %v", file, err)
+			} else if formatted != string(source) {
+				t.Errorf("%s isn't formatted, %s", file, firstDifference(formatted, string(source)))
+			}
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package lispy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+//imageVersion is bumped whenever the format of an image changes in an incompatible way
+const imageVersion = 1
+
+//an image is a JSON snapshot of every binding in an environment which is not part of the prelude
+//native builtins are saved by name, so an image restores them from whatever interpreter loads it
+type image struct {
+	Version  int            `json:"version"`
+	Bindings []imageBinding `json:"bindings"`
+	//atoms are saved once and referenced by index so bindings sharing an atom still share it after a restore
+	Atoms []*imageNode `json:"atoms,omitempty"`
+}
+
+type imageBinding struct {
+	Name  string     `json:"name"`
+	Value *imageNode `json:"value"`
+}
+
+//imageNode is the serialized form of a single Sexp, Kind says which of the other fields are set
+type imageNode struct {
+	Kind  string       `json:"kind"`
+	Int   int          `json:"int,omitempty"`
+	Float float64      `json:"float,omitempty"`
+	Type  TokenType    `json:"type,omitempty"`
+	Value string       `json:"value,omitempty"`
+	Head  *imageNode   `json:"head,omitempty"`
+	Tail  *imageNode   `json:"tail,omitempty"`
+	Items []*imageNode `json:"items,omitempty"`
+	//function definitions
+	Args  []*imageNode `json:"args,omitempty"`
+	Body  *imageNode   `json:"body,omitempty"`
+	Macro bool         `json:"macro,omitempty"`
+	Doc   string       `json:"doc,omitempty"`
+	//the parameter lists of a function with several, each a literal
+	Arities []*imageNode `json:"arities,omitempty"`
+	Module  string       `json:"module,omitempty"`
+	Atom    int          `json:"atom,omitempty"`
+}
+
+//keeps track of atoms while saving or loading an image
+type imageAtoms struct {
+	ids   map[*atom]int
+	nodes []*imageNode
+	atoms []SexpAtom
+}
+
+//SaveImage writes every binding in env that isn't part of the prelude to w, so it can be restored with LoadImage
+//without evaluating the source that created it again
+func (env *Env) SaveImage(w io.Writer) error {
+	img := image{Version: imageVersion, Bindings: make([]imageBinding, 0)}
+	atoms := &imageAtoms{ids: make(map[*atom]int)}
+	names := make([]string, 0)
+	for key := range env.store {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		val := env.store[key]
+		//anonymous functions are stored under fn when evaluated and are never referred to by name
+		if key == "fn" || isPreludeBinding(preludeStore, key, val) {
+			continue
+		}
+		sexp, isSexp := val.(Sexp)
+		if !isSexp && val != nil {
+			return fmt.Errorf("cannot save %s to an image", key)
+		}
+		node, err := encodeImageNode(sexp, atoms)
+		if err != nil {
+			return fmt.Errorf("cannot save %s to an image: %v", key, err)
+		}
+		img.Bindings = append(img.Bindings, imageBinding{Name: key, Value: node})
+	}
+	img.Atoms = atoms.nodes
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", " ")
+	return encoder.Encode(img)
+}
+
+//LoadImage reads an image written by SaveImage and defines each of its bindings in env
+//builtins are restored with the capabilities of env, so an image can't grant itself access to anything
+func (env *Env) LoadImage(r io.Reader) error {
+	var img image
+	if err := json.NewDecoder(r).Decode(&img); err != nil {
+		return err
+	}
+	if img.Version != imageVersion {
+		return fmt.Errorf("cannot load image with version %d, expected version %d", img.Version, imageVersion)
+	}
+	atoms := &imageAtoms{nodes: img.Atoms, atoms: make([]SexpAtom, len(img.Atoms))}
+	//create every atom up front so they can refer to each other
+	for i := range img.Atoms {
+		atoms.atoms[i] = SexpAtom{ref: &atom{watchers: make(map[string]watcher)}}
+	}
+	for i, node := range img.Atoms {
+		val, err := env.decodeImageNode(node, atoms)
+		if err != nil {
+			return err
+		}
+		atoms.atoms[i].ref.value = val
+	}
+	for _, binding := range img.Bindings {
+		val, err := env.decodeImageNode(binding.Value, atoms)
+		if err != nil {
+			return fmt.Errorf("cannot load %s from image: %v", binding.Name, err)
+		}
+		env.store[binding.Name] = val
+	}
+	return nil
+}
+
+func encodeImageNode(s Sexp, atoms *imageAtoms) (*imageNode, error) {
+	switch i := s.(type) {
+	case nil:
+		return &imageNode{Kind: "nil"}, nil
+	case SexpInt:
+		return &imageNode{Kind: "int", Int: int(i)}, nil
+	case SexpFloat:
+		return &imageNode{Kind: "float", Float: float64(i)}, nil
+	case SexpSymbol:
+		return &imageNode{Kind: "symbol", Type: i.ofType, Value: i.value}, nil
+	case SexpPair:
+		head, err := encodeImageNode(i.head, atoms)
+		if err != nil {
+			return nil, err
+		}
+		tail, err := encodeImageNode(i.tail, atoms)
+		if err != nil {
+			return nil, err
+		}
+		return &imageNode{Kind: "pair", Head: head, Tail: tail}, nil
+	case SexpArray:
+		items, err := encodeImageNodes(i.value, atoms)
+		if err != nil {
+			return nil, err
+		}
+		return &imageNode{Kind: "array", Type: i.ofType, Items: items}, nil
+	case SexpFunctionLiteral:
+		return encodeFunction("literal", &i, nil, atoms)
+	case FunctionValue:
+		return encodeFunction("function", i.defn, i.home, atoms)
+	case SexpFunctionCall:
+		args, err := encodeImageNode(i.arguments, atoms)
+		if err != nil {
+			return nil, err
+		}
+		return &imageNode{Kind: "call", Value: i.name, Tail: args}, nil
+	case SexpAtom:
+		id, found := atoms.ids[i.ref]
+		if !found {
+			id = len(atoms.nodes)
+			atoms.ids[i.ref] = id
+			//reserve the slot first in case the atom (indirectly) contains itself
+			atoms.nodes = append(atoms.nodes, nil)
+			node, err := encodeImageNode(i.deref(), atoms)
+			if err != nil {
+				return nil, err
+			}
+			atoms.nodes[id] = node
+		}
+		return &imageNode{Kind: "atom", Atom: id}, nil
+	default:
+		return nil, fmt.Errorf("values of type %s can't be saved", s)
+	}
+}
+
+func encodeImageNodes(nodes []Sexp, atoms *imageAtoms) ([]*imageNode, error) {
+	encoded := make([]*imageNode, 0)
+	for _, node := range nodes {
+		n, err := encodeImageNode(node, atoms)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, n)
+	}
+	return encoded, nil
+}
+
+func encodeFunction(kind string, defn *SexpFunctionLiteral, home *Env, atoms *imageAtoms) (*imageNode, error) {
+	if defn.userfunc != nil && defn.body == nil {
+		//native builtins are referenced by name and never serialized
+		return &imageNode{Kind: "builtin", Value: defn.name}, nil
+	}
+	args, err := encodeImageNodes(defn.arguments.value, atoms)
+	if err != nil {
+		return nil, err
+	}
+	body, err := encodeImageNode(defn.body, atoms)
+	if err != nil {
+		return nil, err
+	}
+	node := &imageNode{Kind: kind, Value: defn.name, Args: args, Body: body, Macro: defn.macro, Doc: defn.doc}
+	for i := range defn.arities {
+		clause, err := encodeFunction("literal", &defn.arities[i], nil, atoms)
+		if err != nil {
+			return nil, err
+		}
+		node.Arities = append(node.Arities, clause)
+	}
+	if home != nil && home.module != nil {
+		node.Module = home.module.name
+	}
+	return node, nil
+}
+
+func (env *Env) decodeImageNode(node *imageNode, atoms *imageAtoms) (Sexp, error) {
+	if node == nil {
+		return nil, errors.New("missing value")
+	}
+	switch node.Kind {
+	case "nil":
+		return nil, nil
+	case "int":
+		return SexpInt(node.Int), nil
+	case "float":
+		return SexpFloat(node.Float), nil
+	case "symbol":
+		return SexpSymbol{ofType: node.Type, value: node.Value}, nil
+	case "pair":
+		head, err := env.decodeImageNode(node.Head, atoms)
+		if err != nil {
+			return nil, err
+		}
+		tail, err := env.decodeImageNode(node.Tail, atoms)
+		if err != nil {
+			return nil, err
+		}
+		return SexpPair{head: head, tail: tail}, nil
+	case "array":
+		items, err := env.decodeImageNodes(node.Items, atoms)
+		if err != nil {
+			return nil, err
+		}
+		return SexpArray{ofType: node.Type, value: items}, nil
+	case "literal", "function":
+		args, err := env.decodeImageNodes(node.Args, atoms)
+		if err != nil {
+			return nil, err
+		}
+		body, err := env.decodeImageNode(node.Body, atoms)
+		if err != nil {
+			return nil, err
+		}
+		literal := SexpFunctionLiteral{name: node.Value, arguments: SexpArray{ofType: ARRAY, value: args}, body: body, macro: node.Macro, doc: node.Doc}
+		for _, arity := range node.Arities {
+			clause, err := env.decodeImageNode(arity, atoms)
+			if err != nil {
+				return nil, err
+			}
+			clauseLiteral, isLiteral := clause.(SexpFunctionLiteral)
+			if !isLiteral {
+				return nil, errors.New("badly formed parameter list of " + node.Value)
+			}
+			literal.arities = append(literal.arities, clauseLiteral)
+		}
+		if node.Kind == "literal" {
+			return literal, nil
+		}
+		function := FunctionValue{defn: &literal}
+		if node.Module != "" {
+			//functions from a module need the module's private definitions, so load it (or use the cached one)
+			err := env.try(func() {
+				function.home = loadModule(env, node.Module).env
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return function, nil
+	case "builtin":
+		return env.builtinFunction(node.Value)
+	case "call":
+		args, err := env.decodeImageNode(node.Tail, atoms)
+		if err != nil {
+			return nil, err
+		}
+		argList, isList := args.(SexpPair)
+		if !isList {
+			return nil, errors.New("badly formed function call")
+		}
+		return SexpFunctionCall{name: node.Value, arguments: argList}, nil
+	case "atom":
+		if node.Atom < 0 || node.Atom >= len(atoms.atoms) {
+			return nil, fmt.Errorf("reference to unknown atom %d", node.Atom)
+		}
+		return atoms.atoms[node.Atom], nil
+	default:
+		return nil, fmt.Errorf("unknown kind of value %s", node.Kind)
+	}
+}
+
+func (env *Env) decodeImageNodes(nodes []*imageNode, atoms *imageAtoms) ([]Sexp, error) {
+	decoded := make([]Sexp, 0)
+	for _, node := range nodes {
+		n, err := env.decodeImageNode(node, atoms)
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, n)
+	}
+	return decoded, nil
+}
+
+//returns the builtin with the given name, subject to the capabilities granted to env
+func (env *Env) builtinFunction(name string) (Sexp, error) {
+	function, found := returnDefinedFunctions()[name]
+	if !found {
+		return nil, fmt.Errorf("unknown builtin %s", name)
+	}
+	if capability, found := returnBuiltinCapabilities()[name]; found && !hasCapability(env.modules.caps, capability) {
+		function = notPermitted(capability)
+	}
+	return makeUserFunction(name, function), nil
+}
+
+/******* images *********/
+//images are saved from and loaded into the environment calling the builtin, so (load-image "f") at the top level
+//works like evaluating every define in the file again
+func callerEnv(env *Env) *Env {
+	if env.parent != nil {
+		return env.parent
+	}
+	return env
+}
+
+//(save-image "path") writes the calling environment to path
+func saveImage(env *Env, name string, args []Sexp) Sexp {
+	path := getPath(name, args)
+	file, err := os.Create(path)
+	if err != nil {
+		fatal("Error creating image ", path, ": ", err)
+	}
+	defer file.Close()
+	if err := callerEnv(env).SaveImage(file); err != nil {
+		fatal("Error saving image ", path, ": ", err)
+	}
+	return SexpSymbol{ofType: STRING, value: path}
+}
+
+//(load-image "path") defines everything saved in the image at path in the calling environment
+func loadImage(env *Env, name string, args []Sexp) Sexp {
+	path := getPath(name, args)
+	file, err := os.Open(path)
+	if err != nil {
+		fatal("Error opening image ", path, ": ", err)
+	}
+	defer file.Close()
+	if err := callerEnv(env).LoadImage(file); err != nil {
+		fatal("Error loading image ", path, ": ", err)
+	}
+	return SexpSymbol{ofType: STRING, value: path}
+}
+
+//helper function to get the path passed to a builtin working with files
+func getPath(name string, args []Sexp) string {
+	if len(args) != 1 {
+		fatal("Error, ", name, " takes exactly one path")
+	}
+	path, isString := args[0].(SexpSymbol)
+	if !isString || path.ofType != STRING {
+		fatal("Error, the path passed to ", name, " must be a string")
+	}
+	return path.value
+}
@@ -0,0 +1,101 @@
+package lispy
+
+import (
+	"errors"
+	"fmt"
+)
+
+//helpers for tools like the repl to look inside an environment without evaluating anything by hand
+
+//looks up a binding in env or any of its parents
+func (env *Env) lookup(name string) (Value, bool) {
+	for curr := env; curr != nil; curr = curr.parent {
+		if val, found := curr.store[name]; found {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+//Doc returns a short description of what is bound to name e.g. the parameters a function takes and its docstring
+func (env *Env) Doc(name string) (string, error) {
+	val, found := env.lookup(name)
+	if !found {
+		return "", fmt.Errorf("%s is not defined", name)
+	}
+	return describe(env, name, val), nil
+}
+
+//Source returns the Lispy code which defined name
+func (env *Env) Source(name string) (string, error) {
+	val, found := env.lookup(name)
+	if !found {
+		return "", fmt.Errorf("%s is not defined", name)
+	}
+	function, isFunc := val.(FunctionValue)
+	if !isFunc {
+		sexp, _ := val.(Sexp)
+		return "(define " + name + " " + readableString(sexp) + ")", nil
+	}
+	if function.defn.userfunc != nil && function.defn.body == nil {
+		return "", fmt.Errorf("%s is a native builtin, so it has no Lispy source", name)
+	}
+	return functionSource(function.defn), nil
+}
+
+//Type evaluates source and returns the type of its (last) result, as the type builtin would
+func (env *Env) Type(source string) (string, error) {
+	ast, err := evalHelper(source)
+	if err != nil {
+		return "", err
+	}
+	if len(ast) == 0 {
+		return "", errors.New("nothing to evaluate")
+	}
+	var typeName string
+	err = env.try(func() {
+		var res Sexp
+		frame := StackFrame{}
+		for _, node := range ast {
+			res = node.Eval(env, &frame, false)
+		}
+		typeName = typeOf(env, "type", []Sexp{nilIfEmpty(res)}).String()
+	})
+	return typeName, err
+}
+
+//Expand returns the code each expression in source expands to, expanding macro calls at the head of an expression
+//until what's left is no longer a macro call
+func (env *Env) Expand(source string) ([]string, error) {
+	ast, err := evalHelper(source)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0)
+	err = env.try(func() {
+		for _, node := range ast {
+			res = append(res, Pretty(env.expand(node), defaultPrettyWidth))
+		}
+	})
+	return res, err
+}
+
+func (env *Env) expand(node Sexp) Sexp {
+	for {
+		call, isList := node.(SexpPair)
+		if !isList {
+			return node
+		}
+		name, isSymbol := call.head.(SexpSymbol)
+		if !isSymbol || name.ofType != SYMBOL {
+			return node
+		}
+		val, _ := env.lookup(name.value)
+		function, isFunc := val.(FunctionValue)
+		if !isFunc || !function.defn.macro {
+			return node
+		}
+		args, _ := call.tail.(SexpPair)
+		node = expandMacro(newFunctionEnv(env), function, args)
+	}
+}
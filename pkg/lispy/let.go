@@ -0,0 +1,98 @@
+package lispy
+
+//let, let* and letrec bind names (or destructuring patterns) while their body is evaluated in a new environment, so
+//neither the bindings nor anything the body defines leak into the one around them
+//(let (x 1) body...) has a single binding and (let ((x 1) (y 2)) body...) any number, the body is an implicit do
+
+//a name or destructuring pattern, and the expression whose value is bound to it
+type letBinding struct {
+	pattern Sexp
+	value   Sexp
+}
+
+//parses (name value) or a list of them
+func letBindings(name string, bindings Sexp) []letBinding {
+	list, isList := bindings.(SexpPair)
+	if !isList {
+		fatal("Error, ", name, " expects bindings like (x 1) or ((x 1) (y 2)) but got ", readableString(bindings))
+	}
+	if isEmptyList(list) {
+		return []letBinding{}
+	}
+	//a single binding starts with its name or pattern, a list of them with the first binding
+	if _, isBinding := list.head.(SexpPair); !isBinding {
+		list = SexpPair{head: list, tail: nil}
+	}
+	parsed := make([]letBinding, 0)
+	for _, binding := range makeList(list) {
+		pair, isPair := binding.(SexpPair)
+		var terms []Sexp
+		if isPair {
+			terms = makeList(pair)
+		}
+		if len(terms) != 2 {
+			fatal("Error, ", name, " expects each binding to be a name and a value e.g. (x 1) but got ", readableString(binding))
+		}
+		switch pattern := terms[0].(type) {
+		case SexpSymbol:
+			if pattern.ofType != SYMBOL {
+				fatal("Error, ", name, " can't bind ", readableString(pattern), ", expected a name or a pattern")
+			}
+		case SexpArray:
+		default:
+			fatal("Error, ", name, " can't bind ", readableString(pattern), ", expected a name or a pattern")
+		}
+		parsed = append(parsed, letBinding{pattern: terms[0], value: terms[1]})
+	}
+	return parsed
+}
+
+/******* let, let* and letrec *********/
+//let evaluates every value before binding any of them, let* binds each in turn so a value can refer to the bindings
+//before it, and letrec binds every name (to ()) first so the values can refer to each other e.g. mutually recursive
+//functions
+func letStatement(env *Env, name string, args []Sexp, allowThunk bool) Sexp {
+	terms, isList := args[0].(SexpPair)
+	if !isList || terms.head == nil {
+		fatal("Error, ", name, " requires bindings and a body")
+	}
+	bindings := letBindings(name, terms.head)
+	body, hasBody := terms.tail.(SexpPair)
+	if !hasBody || body.head == nil {
+		fatal("Error, ", name, " requires a body after its bindings")
+	}
+	letEnv := newFunctionEnv(env)
+	switch name {
+	case "let":
+		values := make([]Sexp, 0)
+		for _, binding := range bindings {
+			values = append(values, binding.value.Eval(env, &StackFrame{}, false))
+		}
+		for i, binding := range bindings {
+			bindPattern(letEnv, binding.pattern, values[i])
+		}
+	case "let*":
+		for _, binding := range bindings {
+			bindPattern(letEnv, binding.pattern, binding.value.Eval(letEnv, &StackFrame{}, false))
+		}
+	case "letrec":
+		for _, binding := range bindings {
+			for _, bound := range boundNames(binding.pattern) {
+				letEnv.store[bound] = SexpPair{}
+			}
+		}
+		for _, binding := range bindings {
+			bindPattern(letEnv, binding.pattern, binding.value.Eval(letEnv, &StackFrame{}, false))
+		}
+	}
+	//only the last expression of the body is in tail position
+	for body.tail != nil {
+		body.head.Eval(letEnv, &StackFrame{}, false)
+		next, isPair := body.tail.(SexpPair)
+		if !isPair {
+			break
+		}
+		body = next
+	}
+	return body.head.Eval(letEnv, &StackFrame{}, allowThunk)
+}
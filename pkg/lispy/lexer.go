@@ -4,6 +4,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"strings"
 	"unicode"
 )
 
@@ -38,6 +39,7 @@ const DEFINE TokenType = "DEFINE"
 const TRUE TokenType = "TRUE"
 const FALSE TokenType = "FALSE"
 const QUOTE TokenType = "QUOTE"
+const DEREF TokenType = "DEREF"
 const UNQUOTE TokenType = "UNQUOTE"
 const DO TokenType = "DO"
 const ARRAY TokenType = "ARRAY"
@@ -46,6 +48,8 @@ const MACRO TokenType = "MACRO"
 type Token struct {
 	Token   TokenType
 	Literal string
+	//line in the source the token starts on, counting from 1
+	Line int
 }
 
 /**********
@@ -56,13 +60,18 @@ type Lexer struct {
 	Position     int
 	ReadPosition int
 	Char         byte
+	//line of the current character
+	Line int
 }
 
 func New(input string) *Lexer {
-	return &Lexer{Input: input, Position: 0, ReadPosition: 0, Char: 0}
+	return &Lexer{Input: input, Position: 0, ReadPosition: 0, Char: 0, Line: 1}
 }
 
 func (l *Lexer) advance() {
+	if l.Char == '\n' {
+		l.Line++
+	}
 	if l.ReadPosition >= len(l.Input) {
 		//Not sure about this bit
 		l.Char = 0
@@ -157,9 +166,33 @@ func (l *Lexer) getUntil(until byte, token TokenType, after bool) Token {
 	return newToken(token, l.Input[old:l.Position])
 }
 
+//function to get a string token, unescaping \" \\ \n and \t along the way
+func (l *Lexer) getString() Token {
+	var val strings.Builder
+	for l.Char != '"' && l.Char != 0 {
+		if l.Char == '\\' && l.peek() != 0 {
+			l.advance()
+			switch l.Char {
+			case 'n':
+				val.WriteByte('\n')
+			case 't':
+				val.WriteByte('\t')
+			default:
+				//covers \" and \\ as well as any character escaped unnecessarily
+				val.WriteByte(l.Char)
+			}
+		} else {
+			val.WriteByte(l.Char)
+		}
+		l.advance()
+	}
+	return newToken(STRING, val.String())
+}
+
 func (l *Lexer) scanToken() Token {
 	//skips white space and new lines
 	l.skipWhiteSpace()
+	line := l.Line
 	var token Token
 	switch l.Char {
 	case '(':
@@ -172,6 +205,8 @@ func (l *Lexer) scanToken() Token {
 		token = newToken(RSQUARE, "]")
 	case '\'':
 		token = newToken(QUOTE, "'")
+	case '@':
+		token = newToken(DEREF, "@")
 	case '-':
 		if unicode.IsDigit(rune(l.peek())) {
 			token = l.getInteger()
@@ -192,7 +227,7 @@ func (l *Lexer) scanToken() Token {
 	case '"':
 		//skip the first "
 		l.advance()
-		token = l.getUntil('"', STRING, false)
+		token = l.getString()
 	case 0:
 		token = newToken(EOF, "EOF")
 	default:
@@ -204,6 +239,7 @@ func (l *Lexer) scanToken() Token {
 		}
 	}
 	l.advance()
+	token.Line = line
 	return token
 }
 
@@ -220,6 +256,35 @@ func (l *Lexer) tokenize(source string) []Token {
 	return tokens
 }
 
+//like tokenize, but keeps comments and sets the literal of every token to the exact text it was read from
+func (l *Lexer) tokenizeRaw() []Token {
+	var tokens []Token
+	l.advance()
+	for l.Position < len(l.Input) {
+		l.skipWhiteSpace()
+		if l.Position >= len(l.Input) {
+			break
+		}
+		start := l.Position
+		next := l.scanToken()
+		end := l.Position
+		if end > len(l.Input) {
+			end = len(l.Input)
+		}
+		//line comments end by consuming the newline after them
+		next.Literal = strings.TrimRight(l.Input[start:end], " \t\r\n")
+		tokens = append(tokens, next)
+	}
+	return tokens
+}
+
+//ReadRaw is like Read, but keeps comments and the exact source text of every token (so e.g. nil stays nil and strings
+//keep their quotes), which tools that write source back out, like the formatter, need
+func ReadRaw(reader io.Reader) []Token {
+	l := New(loadReader(reader))
+	return l.tokenizeRaw()
+}
+
 //Takes as input the source code as a string and returns a list of tokens
 func Read(reader io.Reader) []Token {
 	source := loadReader(reader)
@@ -228,6 +293,30 @@ func Read(reader io.Reader) []Token {
 	return tokens
 }
 
+//IsComplete reports whether source has no unclosed parentheses, brackets or strings, i.e. whether a repl has
+//read the whole expression or should keep reading lines
+func IsComplete(source string) bool {
+	l := New(source)
+	l.advance()
+	depth := 0
+	for l.Position < len(l.Input) {
+		token := l.scanToken()
+		switch token.Token {
+		case LPAREN, LSQUARE:
+			depth++
+		case RPAREN, RSQUARE:
+			depth--
+		case STRING:
+			//scanToken skips the closing ", so we only end up past the end of the input if there wasn't one
+			if l.Position > len(l.Input) {
+				return false
+			}
+		}
+	}
+	//too many closing parentheses can't be fixed by reading more, so leave that for the parser to report
+	return depth <= 0
+}
+
 func loadReader(reader io.Reader) string {
 	//todo: ReadAll puts everything in memory, very inefficient for large files
 	//files will remain small for lispy but potentially adapt to buffered approach (reads in buffers)
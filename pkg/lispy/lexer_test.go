@@ -0,0 +1,73 @@
+package lispy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []Token
+	}{
+		{"", nil},
+		{"()", []Token{{LPAREN, "(", 1}, {RPAREN, ")", 1}}},
+		{"[a b]", []Token{{LSQUARE, "[", 1}, {SYMBOL, "a", 1}, {SYMBOL, "b", 1}, {RSQUARE, "]", 1}}},
+		{"42 -7 3.5 -0.25", []Token{{INTEGER, "42", 1}, {INTEGER, "-7", 1}, {FLOAT, "3.5", 1}, {FLOAT, "-0.25", 1}}},
+		{"- -x", []Token{{SYMBOL, "-", 1}, {SYMBOL, "-x", 1}}},
+		{"define if do macro", []Token{{DEFINE, "define", 1}, {IF, "if", 1}, {DO, "do", 1}, {MACRO, "macro", 1}}},
+		{"true false nil", []Token{{TRUE, "true", 1}, {FALSE, "false", 1}, {FALSE, "false", 1}}},
+		{"'a @b", []Token{{QUOTE, "'", 1}, {SYMBOL, "a", 1}, {DEREF, "@", 1}, {SYMBOL, "b", 1}}},
+		{`"hello world"`, []Token{{STRING, "hello world", 1}}},
+		{`"a\"b\\c\nd\te"`, []Token{{STRING, "a\"b\\c\nd\te", 1}}},
+		{"(f x)(g)", []Token{{LPAREN, "(", 1}, {SYMBOL, "f", 1}, {SYMBOL, "x", 1}, {RPAREN, ")", 1}, {LPAREN, "(", 1}, {SYMBOL, "g", 1}, {RPAREN, ")", 1}}},
+		{"a ; comment\nb", []Token{{SYMBOL, "a", 1}, {SYMBOL, "b", 2}}},
+		{"a ;; inline ; b", []Token{{SYMBOL, "a", 1}, {SYMBOL, "b", 1}}},
+		{"a\n\n  b", []Token{{SYMBOL, "a", 1}, {SYMBOL, "b", 3}}},
+		{"\"two\nlines\" c", []Token{{STRING, "two\nlines", 1}, {SYMBOL, "c", 2}}},
+	}
+	for _, test := range tests {
+		got := Read(strings.NewReader(test.source))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Read(%q) = %v, expected %v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestReadRaw(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []Token
+	}{
+		{"nil ; note\n\"a\\n\"", []Token{{FALSE, "nil", 1}, {COMMENT, "; note", 1}, {STRING, `"a\n"`, 2}}},
+		{"(a ;; inline ; b)", []Token{{LPAREN, "(", 1}, {SYMBOL, "a", 1}, {COMMENT, ";; inline ;", 1}, {SYMBOL, "b", 1}, {RPAREN, ")", 1}}},
+	}
+	for _, test := range tests {
+		got := ReadRaw(strings.NewReader(test.source))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ReadRaw(%q) = %v, expected %v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestIsComplete(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"", true},
+		{"(+ 1 2)", true},
+		{"(+ 1", false},
+		{"(define f [x", false},
+		{"(f x))", true},
+		{`(println "a)`, false},
+		{`(println "(")`, true},
+		{"(f ; )\n", false},
+	}
+	for _, test := range tests {
+		if got := IsComplete(test.source); got != test.want {
+			t.Errorf("IsComplete(%q) = %v, expected %v", test.source, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package lispy
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//modules keeps track of every module loaded by an interpreter, so each one is only evaluated once
+//it is shared by every environment created from the same InitState, including the environments of the modules themselves
+type modules struct {
+	mu sync.Mutex
+	//directories searched (in order) for my.module at my/module.lpy
+	path  []string
+	cache map[string]*module
+	//capabilities granted to the interpreter, and so to every module it loads
+	caps []Capability
+	//extra preludes added with LoadPrelude, loaded into every module as well
+	preludes []fs.FS
+}
+
+//module is a loaded .lpy file with its own environment
+type module struct {
+	name string
+	env  *Env
+	//names declared in (ns name [exports...]), if nil every definition made by the module is exported
+	exports []string
+	//bindings the module started with, which are never exported
+	prelude map[string]Value
+	//the module whose loading required this one, to tell a circular require from one waiting on another goroutine
+	requiredBy *module
+	//closed once the module has been evaluated (or failed to be), loaded is set if it was
+	done   chan struct{}
+	loaded bool
+}
+
+//SetModulePath sets the directories searched when requiring a module
+func (env *Env) SetModulePath(dirs ...string) {
+	env.modules.mu.Lock()
+	defer env.modules.mu.Unlock()
+	env.modules.path = dirs
+}
+
+//AddModulePath appends a directory to those searched when requiring a module
+func (env *Env) AddModulePath(dir string) {
+	env.modules.mu.Lock()
+	defer env.modules.mu.Unlock()
+	env.modules.path = append(env.modules.path, dir)
+}
+
+/******* ns *********/
+//(ns my.module) names the module being loaded, (ns my.module [a b]) also limits what it exports to a and b
+//everything else the module defines stays private to it
+func nsStatement(env *Env, name string, args []Sexp) Sexp {
+	nsArgs, isList := args[0].(SexpPair)
+	if !isList || nsArgs.head == nil {
+		fatal("Error, ns requires the name of the module")
+	}
+	terms := makeList(nsArgs)
+	nsName := terms[0].String()
+	root := env
+	for root.module == nil && root.parent != nil {
+		root = root.parent
+	}
+	if root.module == nil {
+		//not being loaded by require e.g. a script run directly, so just record the name
+		root.module = &module{name: nsName, env: root}
+	}
+	if len(terms) > 1 {
+		exports, isArray := terms[1].(SexpArray)
+		if !isArray {
+			fatal("Error, the exports of ", nsName, " must be an array of symbols")
+		}
+		root.module.exports = make([]string, 0)
+		for _, export := range exports.value {
+			root.module.exports = append(root.module.exports, export.String())
+		}
+	}
+	return SexpSymbol{ofType: SYMBOL, value: nsName}
+}
+
+/******* require *********/
+//(require 'my.module) makes the exports of my.module available as my.module/name
+//(require 'my.module :as m) makes them available as m/name
+//(require 'my.module :refer [a b]) also makes a and b available unqualified
+func requireStatement(env *Env, name string, args []Sexp) Sexp {
+	requireArgs, isList := args[0].(SexpPair)
+	if !isList || requireArgs.head == nil {
+		fatal("Error, require needs the name of a module")
+	}
+	terms := makeList(requireArgs)
+	moduleName := unquoteSymbol(terms[0])
+	alias := moduleName
+	refer := make([]string, 0)
+	for i := 1; i < len(terms); i += 2 {
+		if i+1 >= len(terms) {
+			fatal("Error, missing value for ", terms[i], " in require of ", moduleName)
+		}
+		switch terms[i].String() {
+		case ":as":
+			alias = unquoteSymbol(terms[i+1])
+		case ":refer":
+			names, isArray := terms[i+1].(SexpArray)
+			if !isArray {
+				fatal("Error, :refer expects an array of symbols")
+			}
+			for _, referName := range names.value {
+				refer = append(refer, referName.String())
+			}
+		default:
+			fatal("Error, unknown require option ", terms[i])
+		}
+	}
+	m := loadModule(env, moduleName)
+	exports := m.exported()
+	for key, val := range exports {
+		env.store[alias+"/"+key] = val
+	}
+	for _, referName := range refer {
+		val, found := exports[referName]
+		if !found {
+			fatal("Error, ", referName, " is not exported by ", moduleName)
+		}
+		env.store[referName] = val
+	}
+	return SexpSymbol{ofType: SYMBOL, value: moduleName}
+}
+
+//module names can be passed quoted or not, so strip the quote if there is one
+func unquoteSymbol(s Sexp) string {
+	if quoted, isQuoted := s.(SexpPair); isQuoted {
+		if quote, isQuote := quoted.head.(SexpSymbol); isQuote && (quote.ofType == QUOTE || quote.value == "quote") {
+			if rest, isRest := quoted.tail.(SexpPair); isRest {
+				return rest.head.String()
+			}
+		}
+	}
+	return s.String()
+}
+
+//returns the cached module, loading it first if this is the first time it has been required
+//if another goroutine is already loading it, waits for that to finish rather than loading it twice
+func loadModule(env *Env, name string) *module {
+	//the module being loaded which made this require, if any
+	root := env
+	for root.module == nil && root.parent != nil {
+		root = root.parent
+	}
+	requiredBy := root.module
+	env.modules.mu.Lock()
+	m, found := env.modules.cache[name]
+	if !found {
+		m = &module{name: name, requiredBy: requiredBy, done: make(chan struct{})}
+		env.modules.cache[name] = m
+	}
+	path := env.modules.path
+	env.modules.mu.Unlock()
+	if found {
+		select {
+		case <-m.done:
+		default:
+			for r := requiredBy; r != nil; r = r.requiredBy {
+				if r == m {
+					fatal("Error, circular require of module ", name)
+				}
+			}
+			<-m.done
+		}
+		if !m.loaded {
+			//loading failed in another goroutine, so try again to report the error here
+			return loadModule(env, name)
+		}
+		return m
+	}
+	defer func() {
+		if !m.loaded {
+			//loading failed part way through, so forget the module to let a later require try again
+			env.modules.mu.Lock()
+			delete(env.modules.cache, name)
+			env.modules.mu.Unlock()
+		}
+		close(m.done)
+	}()
+
+	source, file, found := findStdlibModule(name)
+	if !found {
+		source, file, found = findModule(env.modules.caps, path, name)
+	}
+	if !found {
+		fatal("Error, could not find module ", name, " in the module path")
+	}
+	moduleEnv := newModuleEnv(env)
+	m.env, m.prelude = moduleEnv, make(map[string]Value)
+	for key, val := range moduleEnv.store {
+		m.prelude[key] = val
+	}
+	moduleEnv.module = m
+
+	ast, err := evalHelper(source)
+	if err != nil {
+		if parseErr, isParseErr := err.(*ParseError); isParseErr {
+			parseErr.File = file
+		}
+		fatal("Error parsing module ", name, ", ", err)
+	}
+	//an error in the module is raised in the requiring code, so it can be caught there rather than exiting
+	if _, err := moduleEnv.TryEval(WithFile(ast, file)); err != nil {
+		fatal("Error loading module ", name, ", ", err)
+	}
+	m.loaded = true
+	return m
+}
+
+//looks for the source of a module along the module path, returning the source and the file it was found in
+func findModule(caps []Capability, path []string, name string) (string, string, bool) {
+	if !hasCapability(caps, CapFS) {
+		fatal("Error, require of ", name, " is not permitted in this environment (requires the ", CapFS, " capability)")
+	}
+	file := filepath.Join(strings.Split(name, ".")...) + ".lpy"
+	for _, dir := range path {
+		source, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err == nil {
+			return string(source), filepath.Join(dir, file), true
+		} else if !os.IsNotExist(err) {
+			fatal("Error reading module ", name, ": ", err)
+		}
+	}
+	return "", "", false
+}
+
+//a module gets a fresh environment with the same capabilities and streams as the interpreter requiring it
+func newModuleEnv(env *Env) *Env {
+	moduleEnv := newPreludeEnv(env.modules.caps)
+	moduleEnv.ports = env.ports
+	moduleEnv.modules = env.modules
+	moduleEnv.tests = env.tests
+	moduleEnv.debugger = env.debugger
+	moduleEnv.profiler = env.profiler
+	moduleEnv.trace = env.trace
+	env.modules.mu.Lock()
+	preludes := env.modules.preludes
+	env.modules.mu.Unlock()
+	for _, fsys := range preludes {
+		if err := loadDir(fsys, moduleEnv); err != nil {
+			fatal("Error loading prelude: ", err)
+		}
+	}
+	return moduleEnv
+}
+
+//returns the values the module exports, functions remember the module's environment so they can call
+//its private definitions no matter where they end up being called from
+func (m *module) exported() map[string]Value {
+	names := m.exports
+	if names == nil {
+		names = make([]string, 0)
+		for key, val := range m.env.store {
+			//anonymous functions are stored under fn when they are evaluated, which is never something to export
+			if key != "fn" && !isPreludeBinding(m.prelude, key, val) {
+				names = append(names, key)
+			}
+		}
+	}
+	exports := make(map[string]Value)
+	for _, key := range names {
+		val, found := m.env.store[key]
+		if !found {
+			fatal("Error, module ", m.name, " exports ", key, " but never defines it")
+		}
+		if function, isFunc := val.(FunctionValue); isFunc && function.home == nil {
+			function.home = m.env
+			val = function
+		}
+		exports[key] = val
+	}
+	return exports
+}
+
+//checks whether a binding in a module is just the builtin or library function every environment starts with
+func isPreludeBinding(prelude map[string]Value, key string, val Value) bool {
+	original, found := prelude[key]
+	if !found {
+		return false
+	}
+	originalFunc, isOriginalFunc := original.(FunctionValue)
+	function, isFunc := val.(FunctionValue)
+	return isOriginalFunc && isFunc && originalFunc.defn == function.defn
+}
+
+func hasCapability(caps []Capability, capability Capability) bool {
+	for _, c := range caps {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package lispy
+
+import (
+	"fmt"
+	"strings"
+)
+
+//a function can have several parameter lists, each with its own body e.g. (define f ([x] x) ([x y] (+ x y))), and
+//a parameter list can end with optional parameters given defaults e.g. [start stop (step 1)], before any & rest
+//a parameter (or let binding) can also be a pattern which destructures a list or array e.g. [[a b] & rest], or a
+//hash-map by key e.g. [:keys name age]
+
+//the number of arguments a parameter list takes
+type arity struct {
+	params int
+	//optional parameters, which take their default when no argument is given for them
+	optional int
+	//set for functions taking & rest, which take params or more arguments
+	variadic bool
+}
+
+func (a arity) accepts(n int) bool {
+	return n >= a.params && (a.variadic || n <= a.params+a.optional)
+}
+
+func (a arity) String() string {
+	plural := func(n int) string {
+		if n == 1 {
+			return ""
+		}
+		return "s"
+	}
+	switch {
+	case a.variadic:
+		return fmt.Sprintf("at least %d argument%s", a.params, plural(a.params))
+	case a.optional > 0:
+		return fmt.Sprintf("%d to %d arguments", a.params, a.params+a.optional)
+	}
+	return fmt.Sprintf("%d argument%s", a.params, plural(a.params))
+}
+
+//the arities of a function with several parameter lists, which accepts what any of them do
+type arities []arity
+
+func (as arities) accepts(n int) bool {
+	for _, a := range as {
+		if a.accepts(n) {
+			return true
+		}
+	}
+	return false
+}
+
+func (as arities) String() string {
+	described := make([]string, 0)
+	for _, a := range as {
+		described = append(described, a.String())
+	}
+	return strings.Join(described, " or ")
+}
+
+//arity of a parameter array
+func arityOfParams(params SexpArray) arity {
+	var a arity
+	for _, param := range params.value {
+		if _, hasDefault := param.(SexpPair); hasDefault {
+			a.optional++
+		} else if param.String() == "&" {
+			a.variadic = true
+			break
+		} else {
+			a.params++
+		}
+	}
+	return a
+}
+
+//the names a parameter binds: those of the first element of an optional parameter e.g. step for (step 1), or every
+//name in a destructuring pattern
+func boundNames(param Sexp) []string {
+	switch i := param.(type) {
+	case SexpPair:
+		return boundNames(i.head)
+	case SexpArray:
+		names := make([]string, 0)
+		for j, p := range i.value {
+			if p.String() != "&" && !(j == 0 && isKeysPattern(i)) {
+				names = append(names, boundNames(p)...)
+			}
+		}
+		return names
+	}
+	return []string{param.String()}
+}
+
+//the parameter lists of a function literal, each with the body it runs
+func clausesOf(defn *SexpFunctionLiteral) []*SexpFunctionLiteral {
+	if len(defn.arities) == 0 {
+		return []*SexpFunctionLiteral{defn}
+	}
+	clauses := make([]*SexpFunctionLiteral, 0)
+	for i := range defn.arities {
+		clauses = append(clauses, &defn.arities[i])
+	}
+	return clauses
+}
+
+//picks the parameter list (and body) of a user function which takes n arguments, the first one which does if several
+//could, and stops the program naming the signatures it does take if none of them do
+func (funcVal FunctionValue) withArity(name string, n int) FunctionValue {
+	for _, clause := range clausesOf(funcVal.defn) {
+		if arityOfParams(clause.arguments).accepts(n) {
+			return FunctionValue{defn: clause, home: funcVal.home}
+		}
+	}
+	plural := "s"
+	if n == 1 {
+		plural = ""
+	}
+	fatal("Error, ", name, " expects ", signature(name, funcVal.defn), " but was called with ", n, " argument", plural)
+	return funcVal
+}
+
+//binds the arguments of a call to the parameters of defn in env, optional parameters which weren't given an argument
+//are bound to their default, evaluated in env so it can refer to the parameters before it
+func bindParams(env *Env, defn *SexpFunctionLiteral, args []Sexp) {
+	params := defn.arguments.value
+	for i, param := range params {
+		if param.String() == "&" {
+			rest := make([]Sexp, 0)
+			if i < len(args) {
+				rest = args[i:]
+			}
+			bindPattern(env, params[i+1], makeSList(rest))
+			return
+		}
+		if i < len(args) {
+			if optional, hasDefault := param.(SexpPair); hasDefault {
+				param = optional.head
+			}
+			bindPattern(env, param, args[i])
+			continue
+		}
+		optional := param.(SexpPair)
+		bindPattern(env, optional.head, optional.tail.(SexpPair).head.Eval(env, &StackFrame{}, false))
+	}
+}
+
+//whether pattern destructures a hash-map by key e.g. [:keys name age]
+func isKeysPattern(pattern SexpArray) bool {
+	return len(pattern.value) > 0 && pattern.value[0].String() == ":keys"
+}
+
+//binds val to a name, or to the names in a destructuring pattern e.g. [a [b c] & rest] matched against the elements
+//of a list or array, names without an element to match are bound to ()
+func bindPattern(env *Env, pattern Sexp, val Sexp) {
+	arr, isPattern := pattern.(SexpArray)
+	if !isPattern {
+		env.store[pattern.String()] = val
+		return
+	}
+	if isKeysPattern(arr) {
+		bindKeys(env, arr, val)
+		return
+	}
+	var elements []Sexp
+	switch i := val.(type) {
+	case SexpPair:
+		if !isEmptyList(i) {
+			elements = makeList(i)
+		}
+	case SexpArray:
+		elements = i.value
+	case nil:
+	default:
+		fatal("Error, cannot destructure ", readableString(val), " with ", readableString(pattern), ", expected a list or array")
+	}
+	for i, p := range arr.value {
+		if p.String() == "&" {
+			rest := make([]Sexp, 0)
+			if i < len(elements) {
+				rest = elements[i:]
+			}
+			bindPattern(env, arr.value[i+1], makeSList(rest))
+			return
+		}
+		var element Sexp = SexpPair{}
+		if i < len(elements) {
+			element = elements[i]
+		}
+		bindPattern(env, p, element)
+	}
+}
+
+//binds each name in a pattern like [:keys name age] to the value of the key with the same name (as a string) in the
+//hash-map val, looked up with get so it's () if the key is missing
+func bindKeys(env *Env, pattern SexpArray, val Sexp) {
+	get, isFunc := env.store["get"].(FunctionValue)
+	if !isFunc {
+		fatal("Error, cannot destructure ", readableString(val), " with ", readableString(pattern), " without get from the prelude")
+	}
+	if _, isMap := val.(SexpPair); !isMap && val != nil {
+		fatal("Error, cannot destructure ", readableString(val), " with ", readableString(pattern), ", expected a hash-map")
+	}
+	for _, p := range pattern.value[1:] {
+		key := SexpSymbol{ofType: STRING, value: p.String()}
+		env.store[p.String()] = callFunction(env, get, []Sexp{val, key})
+	}
+}
@@ -1,9 +1,7 @@
 package lispy
 
 import (
-	"errors"
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
 )
@@ -106,12 +104,30 @@ type SexpFunctionLiteral struct {
 	arguments SexpArray
 	body      Sexp
 	macro     bool
+	//a function with several parameter lists has a literal for each, holding its parameters and body, instead
+	arities []SexpFunctionLiteral
+	//written between the name and parameters of a define or macro, or registered along with a native builtin
+	doc string
+	//the parameters of a native builtin as they would be written in a definition e.g. [list], since it has no arguments
+	nativeParams string
+	//where the function was defined, the file is only known if whoever read the source set it with WithFile
+	file    string
+	line    int
+	endLine int
 	//userfunc represents a native built-in implementation (which can be overrided e.g. with macros through the body argument)
 	userfunc LispyUserFunction
 }
 
 func (f SexpFunctionLiteral) String() string {
-	if f.userfunc == nil {
+	if f.userfunc == nil && len(f.arities) > 0 {
+		params := make([]string, 0)
+		for _, clause := range f.arities {
+			params = append(params, clause.arguments.String())
+		}
+		return fmt.Sprintf("Define (%s) on (%s)",
+			f.name,
+			strings.Join(params, " "))
+	} else if f.userfunc == nil {
 		return fmt.Sprintf("Define (%s) on (%s)",
 			f.name,
 			f.arguments.String())
@@ -138,13 +154,42 @@ func (f SexpFunctionCall) String() string {
 }
 
 /********** PARSING CODE ****************/
+
+//ParseError is returned by Parse for badly formed source, along with the line it was found on
+type ParseError struct {
+	//File is empty unless set by whoever knows where the tokens were read from
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+//helper to create an error at the first of the remaining tokens, if we ran out of tokens the line is filled in by Parse
+func parseError(tokens []Token, msg string) *ParseError {
+	if len(tokens) == 0 {
+		return &ParseError{Msg: msg}
+	}
+	return &ParseError{Line: tokens[0].Line, Msg: msg}
+}
+
 func Parse(tokens []Token) ([]Sexp, error) {
 	idx, length := 0, len(tokens)
 	nodes := make([]Sexp, 0)
 	for idx < length && tokens[idx].Token != EOF {
 		expr, add, err := parseExpr(tokens[idx:])
 		if err != nil {
-			log.Fatal("Error parsing tokens: ", err)
+			parseErr, isParseErr := err.(*ParseError)
+			if isParseErr && parseErr.Line == 0 && length > 0 {
+				//ran out of tokens, so report the end of the source
+				parseErr.Line = tokens[length-1].Line
+			}
+			return nil, err
 		}
 		idx += add
 		nodes = append(nodes, expr)
@@ -157,7 +202,7 @@ func parseList(tokens []Token) (Sexp, int, error) {
 	idx := 0
 	curr := SexpPair{head: nil, tail: nil}
 	if len(tokens) == 0 {
-		return nil, 0, errors.New("Error parsing list!")
+		return nil, 0, parseError(tokens, "unexpected end of input, missing )")
 	}
 	if tokens[idx].Token == RPAREN {
 		//return idx of 1 so we skip the RPAREN
@@ -192,16 +237,19 @@ func parseArray(tokens []Token) (SexpArray, int, error) {
 		idx += add
 		arr = append(arr, expr)
 	}
+	if idx >= length {
+		return SexpArray{}, 0, parseError(tokens[idx:], "unexpected end of input, missing ]")
+	}
 	return SexpArray{ofType: ARRAY, value: arr}, idx + 1, nil
 }
 
-func getName(token Token) string {
-	if token.Token != SYMBOL {
-		log.Fatal("Unexpected syntax trying to define a function")
+func getName(tokens []Token) (string, error) {
+	if len(tokens) == 0 || tokens[0].Token != SYMBOL {
+		return "", parseError(tokens, "unexpected syntax trying to define a function, expected a name")
 	}
 	//function name will be at index 0
-	name := token.Literal
-	return name
+	name := tokens[0].Literal
+	return name, nil
 }
 
 //parsing
@@ -211,36 +259,182 @@ func parseParameterArray(tokens []Token) (SexpArray, int, error) {
 	//parse arguments first
 	args, add, err := parseArray(tokens[idx:])
 	if err != nil {
-		log.Fatal("Error parsing parameter array")
+		return SexpArray{}, 0, err
 	}
 	idx += add
 	return args, idx, err
 
 }
 
-//parses a function literal
-func parseFunctionLiteral(tokens []Token, name string, macro bool) (Sexp, int, error) {
-	idx := 0
-	var args SexpArray
-	var add int
-	var err error
-	if tokens[idx].Token == LSQUARE {
-		//parse arguments first
-		args, add, _ = parseParameterArray(tokens[idx:])
-		idx += add
-	} else {
-		//means we have a lambda expression here
-		args = SexpArray{}
+//whether tokens start the parameters of a function, either a parameter array or several lists each starting with one
+func startsParams(tokens []Token) bool {
+	return len(tokens) > 0 && (tokens[0].Token == LSQUARE || (len(tokens) > 1 && tokens[0].Token == LPAREN && tokens[1].Token == LSQUARE))
+}
+
+//a define or macro can have a docstring between its name and parameters, returns it and how many tokens it takes up
+func parseDocstring(tokens []Token) (string, int) {
+	if len(tokens) > 1 && tokens[0].Token == STRING && startsParams(tokens[1:]) {
+		return tokens[0].Literal, 1
+	}
+	return "", 0
+}
+
+//whether what follows the name of a define are the parameters of a function, possibly after a docstring
+func startsFunction(tokens []Token) bool {
+	_, skip := parseDocstring(tokens)
+	return startsParams(tokens[skip:])
+}
+
+//checks the parameters of a function are names or destructuring patterns, followed by any optional ones with their
+//defaults e.g. (step 1), followed by & and the name (or pattern) of the rest
+func checkParams(tokens []Token, params SexpArray, name string) error {
+	optional := false
+	for i, param := range params.value {
+		switch p := param.(type) {
+		case SexpSymbol:
+			if p.ofType != SYMBOL {
+				return parseError(tokens, "unexpected parameter "+readableString(p)+" of "+name+", expected a name")
+			}
+			if p.value == "&" {
+				return checkRest(tokens, params, i, name)
+			}
+			if optional {
+				return parseError(tokens, "parameter "+p.value+" of "+name+" comes after an optional parameter, so needs a default too")
+			}
+		case SexpArray:
+			if optional {
+				return parseError(tokens, "parameter "+readableString(p)+" of "+name+" comes after an optional parameter, so needs a default too")
+			}
+			if err := checkPattern(tokens, p, name); err != nil {
+				return err
+			}
+		case SexpPair:
+			optional = true
+			defaultExpr, hasDefault := p.tail.(SexpPair)
+			if !hasDefault || defaultExpr.tail != nil {
+				return parseError(tokens, "optional parameter "+readableString(p)+" of "+name+" should be a name and a default e.g. (step 1)")
+			}
+			switch paramName := p.head.(type) {
+			case SexpSymbol:
+				if paramName.ofType != SYMBOL || paramName.value == "&" {
+					return parseError(tokens, "optional parameter "+readableString(p)+" of "+name+" should be a name and a default e.g. (step 1)")
+				}
+			case SexpArray:
+				if err := checkPattern(tokens, paramName, name); err != nil {
+					return err
+				}
+			default:
+				return parseError(tokens, "optional parameter "+readableString(p)+" of "+name+" should be a name and a default e.g. (step 1)")
+			}
+		default:
+			return parseError(tokens, "unexpected parameter "+readableString(param)+" of "+name+", expected a name")
+		}
+	}
+	return nil
+}
+
+//checks a destructuring pattern e.g. [a [b c] & rest] is made up of names, patterns and an optional & rest, or is
+//[:keys followed by names]
+func checkPattern(tokens []Token, pattern SexpArray, name string) error {
+	if isKeysPattern(pattern) {
+		for _, p := range pattern.value[1:] {
+			if key, isName := p.(SexpSymbol); !isName || key.ofType != SYMBOL || key.value == "&" {
+				return parseError(tokens, "unexpected "+readableString(p)+" in the pattern "+readableString(pattern)+" of "+name+", expected the names of keys")
+			}
+		}
+		return nil
+	}
+	for i, p := range pattern.value {
+		switch inner := p.(type) {
+		case SexpSymbol:
+			if inner.ofType != SYMBOL {
+				return parseError(tokens, "unexpected "+readableString(inner)+" in the pattern "+readableString(pattern)+" of "+name+", expected a name")
+			}
+			if inner.value == "&" {
+				return checkRest(tokens, pattern, i, name)
+			}
+		case SexpArray:
+			if err := checkPattern(tokens, inner, name); err != nil {
+				return err
+			}
+		default:
+			return parseError(tokens, "unexpected "+readableString(p)+" in the pattern "+readableString(pattern)+" of "+name+", expected a name")
+		}
+	}
+	return nil
+}
+
+//checks the & at idx of params is followed by a single name or pattern
+func checkRest(tokens []Token, params SexpArray, idx int, name string) error {
+	if idx != len(params.value)-2 || params.value[idx+1].String() == "&" {
+		return parseError(tokens, "& in the parameters of "+name+" must be followed by a single name or pattern")
+	}
+	switch rest := params.value[idx+1].(type) {
+	case SexpSymbol:
+		if rest.ofType == SYMBOL {
+			return nil
+		}
+	case SexpArray:
+		return checkPattern(tokens, rest, name)
 	}
+	return parseError(tokens, "& in the parameters of "+name+" must be followed by a single name or pattern")
+}
 
+//parses a parameter array and the body after it, up to (and including) the ) closing them
+func parseParamsAndBody(tokens []Token, name string) (SexpArray, Sexp, int, error) {
+	idx := 0
+	args, add, err := parseParameterArray(tokens)
+	if err != nil {
+		return SexpArray{}, nil, 0, err
+	}
+	if err := checkParams(tokens, args, name); err != nil {
+		return SexpArray{}, nil, 0, err
+	}
+	idx += add
 	//parse body of the function which which will be an Sexpr
 	body, addBlock, err := parseExpr(tokens[idx:])
 	if err != nil {
-		return nil, 0, err
+		return SexpArray{}, nil, 0, err
 	}
 	idx += addBlock
+	if idx >= len(tokens) || tokens[idx].Token != RPAREN {
+		return SexpArray{}, nil, 0, parseError(tokens[idx:], "expected ) after the body of "+name+", the body of a function must be a single expression")
+	}
+	return args, body, idx + 1, nil
+}
+
+//parses a function literal, line is the line the definition starts on
+func parseFunctionLiteral(tokens []Token, name string, macro bool, line int) (Sexp, int, error) {
+	doc, idx := parseDocstring(tokens)
+	if idx < len(tokens) && tokens[idx].Token == LPAREN {
+		if macro {
+			return nil, 0, parseError(tokens[idx:], "macro "+name+" can only have one parameter list, it is passed its arguments as a list")
+		}
+		//several parameter lists, each in a list with its body e.g. ([x] x) ([x y] (+ x y))
+		arities := make([]SexpFunctionLiteral, 0)
+		for idx < len(tokens) && tokens[idx].Token == LPAREN {
+			if !startsParams(tokens[idx:]) {
+				return nil, 0, parseError(tokens[idx:], "expected a parameter array and body of "+name+" e.g. ([x] x)")
+			}
+			args, body, add, err := parseParamsAndBody(tokens[idx+1:], name)
+			if err != nil {
+				return nil, 0, err
+			}
+			arities = append(arities, SexpFunctionLiteral{name: name, arguments: args, body: body, doc: doc, line: tokens[idx].Line, endLine: tokens[idx+add].Line})
+			idx += add + 1
+		}
+		if idx >= len(tokens) || tokens[idx].Token != RPAREN {
+			return nil, 0, parseError(tokens[idx:], "expected ) after the parameter lists of "+name)
+		}
+		return SexpFunctionLiteral{name: name, arities: arities, macro: macro, doc: doc, line: line, endLine: tokens[idx].Line}, idx + 1, nil
+	}
+	args, body, add, err := parseParamsAndBody(tokens[idx:], name)
+	if err != nil {
+		return nil, 0, err
+	}
+	idx += add
 	//entire function include define was enclosed in (), note DON'T SKIP 1 otherwise may read code outside function
-	return SexpFunctionLiteral{name: name, arguments: args, body: body, userfunc: nil, macro: macro}, idx + 1, nil
+	return SexpFunctionLiteral{name: name, arguments: args, body: body, userfunc: nil, macro: macro, doc: doc, line: line, endLine: tokens[idx-1].Line}, idx, nil
 }
 
 //parses a single expression (list or non-list)
@@ -250,16 +444,20 @@ func parseExpr(tokens []Token) (Sexp, int, error) {
 	var err error
 	var add int
 	if len(tokens) == 0 {
-		return nil, 0, errors.New("Error parsing expression!")
+		return nil, 0, parseError(tokens, "unexpected end of input")
 	}
 	switch tokens[idx].Token {
 	case DEFINE:
-		//look ahead one to check if it's a function or just data-binding
-		if idx+2 < len(tokens) && (tokens[idx+2].Token == LSQUARE) {
+		//look ahead past the name (and a docstring) to check if it's a function or just data-binding
+		if idx+2 < len(tokens) && startsFunction(tokens[idx+2:]) {
 			idx++
 			//skip define token
-			name := getName(tokens[idx])
-			expr, add, err = parseFunctionLiteral(tokens[idx+1:], name, false)
+			var name string
+			name, err = getName(tokens[idx:])
+			if err != nil {
+				return nil, 0, err
+			}
+			expr, add, err = parseFunctionLiteral(tokens[idx+1:], name, false, tokens[0].Line)
 		} else {
 			expr = SexpSymbol{ofType: tokens[idx].Token, value: tokens[idx].Literal}
 			//POSSIBLE FEATURE AMMENDMENT: If I add local binding via let similar to Clojure, will be added here
@@ -267,20 +465,26 @@ func parseExpr(tokens []Token) (Sexp, int, error) {
 		}
 	case MACRO:
 		idx++
-		name := getName(tokens[idx])
-		expr, add, err = parseFunctionLiteral(tokens[idx+1:], name, true)
+		var name string
+		name, err = getName(tokens[idx:])
+		if err != nil {
+			return nil, 0, err
+		}
+		expr, add, err = parseFunctionLiteral(tokens[idx+1:], name, true, tokens[0].Line)
 	case LSQUARE:
 		//if we reach here, then parsing a quote with square brackets
-		expr, add, _ = parseParameterArray(tokens[idx:])
+		expr, add, err = parseParameterArray(tokens[idx:])
 	case LPAREN:
 		idx++
 		//check if anonymous function
-		if idx+1 < len(tokens) && tokens[idx].Literal == "fn" && tokens[idx+1].Token == LSQUARE {
+		if idx+1 < len(tokens) && tokens[idx].Literal == "fn" && startsParams(tokens[idx+1:]) {
 			//skip fn
 			idx++
 			//give anonymous functions the same name because by definition, should not be able to refer
 			//to them after they have been defined (designed to execute there and then)
-			expr, add, err = parseFunctionLiteral(tokens[idx:], "fn", false)
+			expr, add, err = parseFunctionLiteral(tokens[idx:], "fn", false, tokens[0].Line)
+		} else if idx >= len(tokens) {
+			return nil, 0, parseError(tokens[idx:], "unexpected end of input, missing )")
 		} else if tokens[idx].Token == RPAREN {
 			//check for empty list
 			expr = SexpPair{head: nil, tail: nil}
@@ -306,18 +510,26 @@ func parseExpr(tokens []Token) (Sexp, int, error) {
 		idx++
 		nextExpr, toAdd, errorL := parseExpr(tokens[idx:])
 		if errorL != nil {
-			log.Fatal("Error parsing quote!")
+			return nil, 0, errorL
 		}
 		expr = makeSList([]Sexp{SexpSymbol{ofType: QUOTE, value: "quote"}, nextExpr})
 		add = toAdd
+	case DEREF:
+		//@a is shorthand for (deref a)
+		idx++
+		nextExpr, toAdd, errorL := parseExpr(tokens[idx:])
+		if errorL != nil {
+			return nil, 0, errorL
+		}
+		expr = makeSList([]Sexp{SexpSymbol{ofType: SYMBOL, value: "deref"}, nextExpr})
+		add = toAdd
 	//eventually refactor to handle other symbols like identifiers
 	//create a map with all of these operators pre-stored and just get, or default, passing in tokentype to check if it exists
 	case STRING, TRUE, FALSE, IF, DO, SYMBOL:
 		expr = SexpSymbol{ofType: tokens[idx].Token, value: tokens[idx].Literal}
 		add = 1
 	default:
-		fmt.Println(tokens[idx:])
-		log.Fatal("error parsing")
+		return nil, 0, parseError(tokens, "unexpected "+tokens[idx].Literal)
 	}
 	if err != nil {
 		return nil, 0, err
@@ -334,6 +546,39 @@ func makeSList(expressions []Sexp) Sexp {
 	return consHelper(expressions[0], makeSList(expressions[1:]))
 }
 
+//WithFile records the file nodes were read from in the functions they define, so e.g. the debugger can say where they are
+func WithFile(nodes []Sexp, file string) []Sexp {
+	withFile := make([]Sexp, len(nodes))
+	for i, node := range nodes {
+		withFile[i] = setFile(node, file)
+	}
+	return withFile
+}
+
+func setFile(node Sexp, file string) Sexp {
+	switch i := node.(type) {
+	case SexpFunctionLiteral:
+		i.file = file
+		i.body = setFile(i.body, file)
+		arities := make([]SexpFunctionLiteral, 0)
+		for _, clause := range i.arities {
+			arities = append(arities, setFile(clause, file).(SexpFunctionLiteral))
+		}
+		if len(arities) > 0 {
+			i.arities = arities
+		}
+		return i
+	case SexpPair:
+		if i.head == nil {
+			return i
+		}
+		return SexpPair{head: setFile(i.head, file), tail: setFile(i.tail, file)}
+	case SexpArray:
+		return SexpArray{ofType: i.ofType, value: WithFile(i.value, file)}
+	}
+	return node
+}
+
 /*
 Grammar
 
@@ -0,0 +1,83 @@
+package lispy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func sym(name string) SexpSymbol {
+	return SexpSymbol{ofType: SYMBOL, value: name}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []Sexp
+	}{
+		{"", []Sexp{}},
+		{"1 2.5 -3", []Sexp{SexpInt(1), SexpFloat(2.5), SexpInt(-3)}},
+		{`x "s" true nil`, []Sexp{sym("x"), SexpSymbol{ofType: STRING, value: "s"}, SexpSymbol{ofType: TRUE, value: "true"}, SexpSymbol{ofType: FALSE, value: "false"}}},
+		{"()", []Sexp{SexpPair{}}},
+		{"(+ 1 2)", []Sexp{makeSList([]Sexp{sym("+"), SexpInt(1), SexpInt(2)})}},
+		{"(a (b c))", []Sexp{makeSList([]Sexp{sym("a"), makeSList([]Sexp{sym("b"), sym("c")})})}},
+		{"[1 a]", []Sexp{SexpArray{ofType: ARRAY, value: []Sexp{SexpInt(1), sym("a")}}}},
+		{"'a", []Sexp{makeSList([]Sexp{SexpSymbol{ofType: QUOTE, value: "quote"}, sym("a")})}},
+		{"@a", []Sexp{makeSList([]Sexp{sym("deref"), sym("a")})}},
+		{"(if c 1 2)", []Sexp{makeSList([]Sexp{SexpSymbol{ofType: IF, value: "if"}, sym("c"), SexpInt(1), SexpInt(2)})}},
+		{"(define x 5)", []Sexp{makeSList([]Sexp{SexpSymbol{ofType: DEFINE, value: "define"}, sym("x"), SexpInt(5)})}},
+		{"(define f [x] x)", []Sexp{makeSList([]Sexp{SexpFunctionLiteral{name: "f", arguments: SexpArray{ofType: ARRAY, value: []Sexp{sym("x")}}, body: sym("x"), line: 1, endLine: 1}})}},
+		{`(define f "Returns x." [x] x)`, []Sexp{makeSList([]Sexp{SexpFunctionLiteral{name: "f", doc: "Returns x.", arguments: SexpArray{ofType: ARRAY, value: []Sexp{sym("x")}}, body: sym("x"), line: 1, endLine: 1}})}},
+		{`(define s "not a docstring")`, []Sexp{makeSList([]Sexp{SexpSymbol{ofType: DEFINE, value: "define"}, sym("s"), SexpSymbol{ofType: STRING, value: "not a docstring"}})}},
+		{"(define f [x (y 1)] x)", []Sexp{makeSList([]Sexp{SexpFunctionLiteral{name: "f", arguments: SexpArray{ofType: ARRAY, value: []Sexp{sym("x"), makeSList([]Sexp{sym("y"), SexpInt(1)})}}, body: sym("x"), line: 1, endLine: 1}})}},
+		{"(define f ([] 0)\n  ([x] x))", []Sexp{makeSList([]Sexp{SexpFunctionLiteral{name: "f", arities: []SexpFunctionLiteral{
+			{name: "f", arguments: SexpArray{ofType: ARRAY, value: []Sexp{}}, body: SexpInt(0), line: 1, endLine: 1},
+			{name: "f", arguments: SexpArray{ofType: ARRAY, value: []Sexp{sym("x")}}, body: sym("x"), line: 2, endLine: 2},
+		}, line: 1, endLine: 2}})}},
+		{"(fn [[a & b]] a)", []Sexp{SexpFunctionLiteral{name: "fn", arguments: SexpArray{ofType: ARRAY, value: []Sexp{SexpArray{ofType: ARRAY, value: []Sexp{sym("a"), sym("&"), sym("b")}}}}, body: sym("a"), line: 1, endLine: 1}}},
+		{"(macro m [t] t)", []Sexp{makeSList([]Sexp{SexpFunctionLiteral{name: "m", arguments: SexpArray{ofType: ARRAY, value: []Sexp{sym("t")}}, body: sym("t"), macro: true, line: 1, endLine: 1}})}},
+		{"(fn [] 1)", []Sexp{SexpFunctionLiteral{name: "fn", arguments: SexpArray{ofType: ARRAY, value: []Sexp{}}, body: SexpInt(1), line: 1, endLine: 1}}},
+		{"\n(define g []\n  1\n)", []Sexp{makeSList([]Sexp{SexpFunctionLiteral{name: "g", arguments: SexpArray{ofType: ARRAY, value: []Sexp{}}, body: SexpInt(1), line: 2, endLine: 4}})}},
+	}
+	for _, test := range tests {
+		got, err := Parse(Read(strings.NewReader(test.source)))
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Parse(%q) = %#v, expected %#v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"(+ 1 2", "line 1: unexpected end of input, missing )"},
+		{"(\n(", "line 2: unexpected end of input, missing )"},
+		{"[1 2", "line 1: unexpected end of input, missing ]"},
+		{")", "line 1: unexpected )"},
+		{"(define 5 [x] x)", "line 1: unexpected syntax trying to define a function, expected a name"},
+		{"(define f [(y)] y)", "line 1: optional parameter (y) of f should be a name and a default e.g. (step 1)"},
+		{"(define f [(x 1) y] y)", "line 1: parameter y of f comes after an optional parameter, so needs a default too"},
+		{"(define f [& a b] a)", "line 1: & in the parameters of f must be followed by a single name or pattern"},
+		{"(define f [[a 1]] a)", "line 1: unexpected 1 in the pattern [a 1] of f, expected a name"},
+		{"(define f [(x 1) [y]] y)", "line 1: parameter [y] of f comes after an optional parameter, so needs a default too"},
+		{"(define f [[:keys a [b]]] a)", "line 1: unexpected [b] in the pattern [:keys a [b]] of f, expected the names of keys"},
+		{"(define f [([a 1] ())] a)", "line 1: unexpected 1 in the pattern [a 1] of f, expected a name"},
+		{"(define f ([x] x) 5)", "line 1: expected ) after the parameter lists of f"},
+		{"(macro m ([t] t))", "line 1: macro m can only have one parameter list, it is passed its arguments as a list"},
+		{"(define f [x] x x)", "line 1: expected ) after the body of f, the body of a function must be a single expression"},
+	}
+	for _, test := range tests {
+		_, err := Parse(Read(strings.NewReader(test.source)))
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, expected %q", test.source, test.want)
+		} else if err.Error() != test.want {
+			t.Errorf("Parse(%q) failed with %q, expected %q", test.source, err, test.want)
+		}
+	}
+}
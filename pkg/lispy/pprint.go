@@ -0,0 +1,239 @@
+package lispy
+
+import (
+	"fmt"
+	"strings"
+)
+
+//width pprint breaks lines at when it isn't given one
+const defaultPrettyWidth = 80
+
+//forms whose body is indented by two spaces instead of being lined up with the first argument, along with
+//how many of their elements stay on the first line e.g. (define name [args] on one line, then the body
+var bodyForms = map[string]int{
+	"define":  2,
+	"macro":   2,
+	"fn":      2,
+	"let":     2,
+	"let*":    2,
+	"letrec":  2,
+	"when":    2,
+	"if":      2,
+	"ns":      2,
+	"deftest": 2,
+	"testing": 2,
+	"do":      1,
+	"cond":    1,
+	"select":  1,
+}
+
+//prettyNode is the tree the pretty printer lays out, either a single atom or a list of elements between open and close
+type prettyNode struct {
+	atom  string
+	open  string
+	close string
+	items []prettyNode
+	//number of elements kept on the first line before the rest are indented as a body, 0 if this isn't a body form
+	header int
+	//set for lists starting with a symbol, i.e. function calls rather than data
+	call bool
+}
+
+func (n prettyNode) isAtom() bool {
+	return n.open == ""
+}
+
+//the whole node on one line
+func (n prettyNode) flat() string {
+	if n.isAtom() {
+		return n.atom
+	}
+	items := make([]string, 0)
+	for _, item := range n.items {
+		items = append(items, item.flat())
+	}
+	return n.open + strings.Join(items, " ") + n.close
+}
+
+//Pretty returns the readable form of a value (like prn) broken over lines and indented so that no line is longer
+//than width where possible, functions are printed as the code that defines them
+func Pretty(v Value, width int) string {
+	sexp, isSexp := v.(Sexp)
+	if !isSexp {
+		return v.String()
+	}
+	var sb strings.Builder
+	layout(&sb, toPrettyNode(sexp), 0, width)
+	return sb.String()
+}
+
+func toPrettyNode(s Sexp) prettyNode {
+	switch i := s.(type) {
+	case SexpPair:
+		if i.head == nil {
+			return prettyNode{atom: "()"}
+		}
+		node := prettyNode{open: "(", close: ")"}
+		var pair Sexp = i
+		for {
+			curr, isPair := pair.(SexpPair)
+			if !isPair {
+				//improper list ending in a non-list tail
+				node.items = append(node.items, prettyNode{atom: "."}, toPrettyNode(pair))
+				break
+			}
+			if curr.head != nil {
+				node.items = append(node.items, toPrettyNode(curr.head))
+			}
+			if curr.tail == nil {
+				break
+			}
+			pair = curr.tail
+		}
+		if quote, isQuote := i.head.(SexpSymbol); isQuote && quote.ofType == QUOTE && len(node.items) == 2 {
+			//print quoted forms the way they were written, i.e. 'x rather than (quote x)
+			return prettyNode{open: "'", items: node.items[1:]}
+		}
+		if head, isSymbol := i.head.(SexpSymbol); isSymbol && head.ofType != STRING {
+			node.call = true
+			node.header = bodyForms[head.value]
+		}
+		return node
+	case SexpArray:
+		node := prettyNode{open: "[", close: "]"}
+		for _, item := range i.value {
+			node.items = append(node.items, toPrettyNode(item))
+		}
+		return node
+	case SexpFunctionLiteral:
+		return functionPrettyNode(&i)
+	case FunctionValue:
+		return functionPrettyNode(i.defn)
+	}
+	return prettyNode{atom: readableString(s)}
+}
+
+//functions are laid out as the code defining them e.g. (define square [x] (* x x))
+func functionPrettyNode(defn *SexpFunctionLiteral) prettyNode {
+	if defn.userfunc != nil && defn.body == nil {
+		return prettyNode{atom: defn.String()}
+	}
+	node := prettyNode{open: "(", close: ")"}
+	if defn.name == "fn" {
+		node.items = []prettyNode{{atom: "fn"}}
+	} else if defn.macro {
+		node.items = []prettyNode{{atom: "macro"}, {atom: defn.name}}
+	} else {
+		node.items = []prettyNode{{atom: "define"}, {atom: defn.name}}
+	}
+	if defn.doc != "" {
+		node.items = append(node.items, prettyNode{atom: readableString(SexpSymbol{ofType: STRING, value: defn.doc})})
+	}
+	if len(defn.arities) > 0 {
+		//each parameter list goes on its own line along with its body
+		node.header = len(node.items)
+		for _, clause := range defn.arities {
+			clauseNode := prettyNode{open: "(", close: ")", items: []prettyNode{toPrettyNode(clause.arguments), toPrettyNode(clause.body)}}
+			clauseNode.header = 1
+			node.items = append(node.items, clauseNode)
+		}
+		return node
+	}
+	node.items = append(node.items, toPrettyNode(defn.arguments), toPrettyNode(defn.body))
+	node.header = len(node.items) - 1
+	return node
+}
+
+//writes node to sb, assuming the cursor is at column col
+func layout(sb *strings.Builder, node prettyNode, col int, width int) {
+	flat := node.flat()
+	if node.isAtom() || len(node.items) == 0 || col+len(flat) <= width {
+		sb.WriteString(flat)
+		return
+	}
+	sb.WriteString(node.open)
+	col += len(node.open)
+	//column the elements after the first line are indented to
+	indent := col
+	//number of elements to put on the first line
+	first := 1
+	if node.header > 0 {
+		indent = col + 1
+		first = node.header
+	} else if node.call && len(node.items) > 1 {
+		//function calls line their arguments up after the name of the function
+		first = 2
+		indent = col + len(node.items[0].atom) + 1
+	} else if allAtoms(node.items) {
+		//data made up of atoms e.g. a long array of numbers fills each line rather than taking one line per element
+		fill(sb, node.items, col, width)
+		sb.WriteString(node.close)
+		return
+	}
+	if first > len(node.items) {
+		first = len(node.items)
+	}
+	curr := col
+	for i, item := range node.items[:first] {
+		if i > 0 {
+			sb.WriteString(" ")
+			curr++
+		}
+		layout(sb, item, curr, width)
+		curr = currentColumn(sb)
+	}
+	for _, item := range node.items[first:] {
+		sb.WriteString("\n" + strings.Repeat(" ", indent))
+		layout(sb, item, indent, width)
+	}
+	sb.WriteString(node.close)
+}
+
+func allAtoms(items []prettyNode) bool {
+	for _, item := range items {
+		if !item.isAtom() {
+			return false
+		}
+	}
+	return true
+}
+
+//writes atoms separated by spaces, starting a new line at col whenever the next one wouldn't fit
+func fill(sb *strings.Builder, items []prettyNode, col int, width int) {
+	curr := col
+	for i, item := range items {
+		if i > 0 && curr+1+len(item.atom) > width {
+			sb.WriteString("\n" + strings.Repeat(" ", col))
+			curr = col
+		} else if i > 0 {
+			sb.WriteString(" ")
+			curr++
+		}
+		sb.WriteString(item.atom)
+		curr += len(item.atom)
+	}
+}
+
+//column the next character written to sb will be at
+func currentColumn(sb *strings.Builder) int {
+	written := sb.String()
+	return len(written) - (strings.LastIndex(written, "\n") + 1)
+}
+
+/******* pprint *********/
+//(pprint x) prints x readably over as many lines as it needs to fit in 80 columns, (pprint x width) for any other width
+func pprint(env *Env, name string, args []Sexp) Sexp {
+	if len(args) == 0 || len(args) > 2 {
+		fatal("Error, ", name, " takes a value and optionally the width to print it in")
+	}
+	width := defaultPrettyWidth
+	if len(args) == 2 {
+		w, isInt := args[1].(SexpInt)
+		if !isInt || w <= 0 {
+			fatal("Error, the width passed to ", name, " must be a positive integer")
+		}
+		width = int(w)
+	}
+	fmt.Fprintln(env.ports.out, Pretty(args[0], width))
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
@@ -0,0 +1,418 @@
+package lispy
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//the profiler keeps a stack of the Lispy functions being called (pushed and popped around their bodies like the
+//debugger, so a tail call replaces the call it was made from) and a goroutine samples it at a fixed interval.
+//Time spent in builtins counts towards the Lispy function which called them. Goroutines started by spawn have
+//their own stack, sampled along with the rest
+
+//Profiler samples which Lispy functions a program is running, attach it with SetProfiler
+type Profiler struct {
+	*profileData
+	//the functions being called in the goroutine this Profiler is attached to
+	stack *profileStack
+}
+
+//what is shared by the profilers of every goroutine
+type profileData struct {
+	mu       sync.Mutex
+	interval time.Duration
+	//the stacks of every goroutine being profiled
+	stacks []*profileStack
+	calls  map[profileFunc]int
+	//samples taken of each stack, keyed by stackKey
+	samples map[string]*profileSample
+	start   time.Time
+	//when the last sample was taken
+	last    time.Time
+	elapsed time.Duration
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+//the functions a goroutine is calling, innermost last
+type profileStack struct {
+	funcs []profileFunc
+	//set for a goroutine started by spawn, which isn't sampled while it isn't in any Lispy function
+	spawned bool
+}
+
+//functions are identified by where they were defined, so e.g. every closure made from the same fn counts as one
+type profileFunc struct {
+	name string
+	file string
+	line int
+}
+
+func (f profileFunc) String() string {
+	switch {
+	case f.file != "":
+		return fmt.Sprintf("%s %s:%d", f.name, f.file, f.line)
+	case f.line != 0:
+		return fmt.Sprintf("%s line %d", f.name, f.line)
+	}
+	return f.name
+}
+
+//time which isn't spent in any Lispy function
+var topLevel = profileFunc{name: "(top level)"}
+
+type profileSample struct {
+	//innermost last
+	stack []profileFunc
+	count int
+	//the time since the sample before each one, which is longer than the interval if the sampler didn't get to run
+	//e.g. because there's only one CPU
+	time time.Duration
+}
+
+//NewProfiler returns a profiler which samples every interval once started
+func NewProfiler(interval time.Duration) *Profiler {
+	stack := &profileStack{}
+	data := &profileData{interval: interval, stacks: []*profileStack{stack}, calls: make(map[profileFunc]int), samples: make(map[string]*profileSample)}
+	return &Profiler{profileData: data, stack: stack}
+}
+
+//a profiler for a spawned goroutine, whose stack is sampled along with the others until release is called
+func (p *Profiler) fork() *Profiler {
+	stack := &profileStack{spawned: true}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stacks = append(p.stacks, stack)
+	return &Profiler{profileData: p.profileData, stack: stack}
+}
+
+//stops sampling the stack of a spawned goroutine once it has finished
+func (p *Profiler) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, stack := range p.stacks {
+		if stack == p.stack {
+			p.stacks = append(p.stacks[:i], p.stacks[i+1:]...)
+			return
+		}
+	}
+}
+
+//SetProfiler attaches p to env so calls made in it (or any environment created from it) are profiled
+func (env *Env) SetProfiler(p *Profiler) {
+	env.profiler = p
+}
+
+//Start starts sampling
+func (p *Profiler) Start() {
+	p.start = time.Now()
+	p.last = p.start
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				p.sample(now)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+//Stop stops sampling, after which the results can be written out
+func (p *Profiler) Stop() {
+	close(p.stop)
+	<-p.done
+	p.elapsed = time.Since(p.start)
+}
+
+//every goroutine's stack is sampled, so time is counted once for each goroutine running at the time
+func (p *Profiler) sample(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, stack := range p.stacks {
+		if stack.spawned && len(stack.funcs) == 0 {
+			continue
+		}
+		key := stackKey(stack.funcs)
+		s, found := p.samples[key]
+		if !found {
+			s = &profileSample{stack: append([]profileFunc{}, stack.funcs...)}
+			p.samples[key] = s
+		}
+		s.count++
+		s.time += now.Sub(p.last)
+	}
+	p.last = now
+}
+
+func stackKey(stack []profileFunc) string {
+	parts := make([]string, len(stack))
+	for i, f := range stack {
+		parts[i] = f.String()
+	}
+	return strings.Join(parts, "\n")
+}
+
+//evaluates the body of the call in thunk with it on the profiler's stack
+func (p *Profiler) call(thunk FunctionThunkValue) Sexp {
+	defn := thunk.function.defn
+	f := profileFunc{name: defn.name, file: defn.file, line: defn.line}
+	p.mu.Lock()
+	p.stack.funcs = append(p.stack.funcs, f)
+	p.calls[f]++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.stack.funcs = p.stack.funcs[:len(p.stack.funcs)-1]
+		p.mu.Unlock()
+	}()
+	if thunk.env.debugger != nil {
+		return thunk.env.debugger.call(thunk)
+	}
+	return defn.body.Eval(thunk.env, &StackFrame{}, true)
+}
+
+type profileEntry struct {
+	function  profileFunc
+	flat, cum time.Duration
+	calls     int
+}
+
+//the time spent in and under each function, most flat time first, along with the total time sampled
+func (p *Profiler) entries() ([]profileEntry, time.Duration) {
+	byFunc := make(map[profileFunc]*profileEntry)
+	entry := func(f profileFunc) *profileEntry {
+		e, found := byFunc[f]
+		if !found {
+			e = &profileEntry{function: f, calls: p.calls[f]}
+			byFunc[f] = e
+		}
+		return e
+	}
+	var total time.Duration
+	for _, s := range p.samples {
+		total += s.time
+		if len(s.stack) == 0 {
+			e := entry(topLevel)
+			e.flat += s.time
+			e.cum += s.time
+			continue
+		}
+		entry(s.stack[len(s.stack)-1]).flat += s.time
+		//a recursive function is only counted once per sample
+		counted := make(map[profileFunc]bool)
+		for _, f := range s.stack {
+			if !counted[f] {
+				entry(f).cum += s.time
+				counted[f] = true
+			}
+		}
+	}
+	//functions which were called but never sampled
+	for f := range p.calls {
+		entry(f)
+	}
+	entries := make([]profileEntry, 0, len(byFunc))
+	for _, e := range byFunc {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].flat != entries[j].flat {
+			return entries[i].flat > entries[j].flat
+		}
+		if entries[i].cum != entries[j].cum {
+			return entries[i].cum > entries[j].cum
+		}
+		return entries[i].function.String() < entries[j].function.String()
+	})
+	return entries, total
+}
+
+//WriteReport writes a table of the time spent in each function (flat) and in it and anything it called (cum),
+//estimated from the samples, along with how many times it was called
+func (p *Profiler) WriteReport(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entries, total := p.entries()
+	samples := 0
+	for _, s := range p.samples {
+		samples += s.count
+	}
+	if _, err := fmt.Fprintf(w, "Total: %v, %d samples\n", p.elapsed.Round(time.Millisecond), samples); err != nil {
+		return err
+	}
+	percent := func(n time.Duration) float64 {
+		if total == 0 {
+			return 0
+		}
+		return 100 * float64(n) / float64(total)
+	}
+	fmt.Fprintf(w, "%10s %6s %10s %6s %10s  %s\n", "flat", "flat%", "cum", "cum%", "calls", "function")
+	for _, e := range entries {
+		_, err := fmt.Fprintf(w, "%10v %5.1f%% %10v %5.1f%% %10d  %s\n",
+			e.flat.Round(time.Millisecond), percent(e.flat), e.cum.Round(time.Millisecond), percent(e.cum), e.calls, e.function)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//WriteProfile writes the samples as a gzipped pprof profile, with a location for every function at the line it was
+//defined on, so it can be explored with go tool pprof
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	strs := []string{""}
+	index := make(map[string]int64)
+	str := func(s string) int64 {
+		if s == "" {
+			return 0
+		}
+		if i, found := index[s]; found {
+			return i
+		}
+		index[s] = int64(len(strs))
+		strs = append(strs, s)
+		return index[s]
+	}
+	var prof protoBuffer
+	valueType := func(field int, typ string, unit string) {
+		var vt protoBuffer
+		vt.int64Field(1, str(typ))
+		vt.int64Field(2, str(unit))
+		prof.message(field, &vt)
+	}
+	valueType(1, "samples", "count")
+	valueType(1, "cpu", "nanoseconds")
+	//every function gets one location, both numbered from 1
+	ids := make(map[profileFunc]uint64)
+	funcs := make([]profileFunc, 0)
+	id := func(f profileFunc) uint64 {
+		if i, found := ids[f]; found {
+			return i
+		}
+		funcs = append(funcs, f)
+		ids[f] = uint64(len(funcs))
+		return ids[f]
+	}
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := p.samples[key]
+		stack := s.stack
+		if len(stack) == 0 {
+			stack = []profileFunc{topLevel}
+		}
+		//pprof lists the innermost location first
+		locations := make([]uint64, len(stack))
+		for i, f := range stack {
+			locations[len(stack)-1-i] = id(f)
+		}
+		var sample protoBuffer
+		sample.packedField(1, locations)
+		sample.packedField(2, []uint64{uint64(s.count), uint64(s.time)})
+		prof.message(2, &sample)
+	}
+	for i, f := range funcs {
+		var line protoBuffer
+		line.uint64Field(1, uint64(i+1))
+		line.int64Field(2, int64(f.line))
+		var location protoBuffer
+		location.uint64Field(1, uint64(i+1))
+		location.message(4, &line)
+		prof.message(4, &location)
+	}
+	for i, f := range funcs {
+		var function protoBuffer
+		function.uint64Field(1, uint64(i+1))
+		function.int64Field(2, str(f.name))
+		function.int64Field(3, str(f.name))
+		function.int64Field(4, str(f.file))
+		function.int64Field(5, int64(f.line))
+		prof.message(5, &function)
+	}
+	//the period type and duration go before the string table since they add to it
+	var period protoBuffer
+	period.int64Field(1, str("cpu"))
+	period.int64Field(2, str("nanoseconds"))
+	var rest protoBuffer
+	rest.int64Field(9, p.start.UnixNano())
+	rest.int64Field(10, int64(p.elapsed))
+	rest.message(11, &period)
+	rest.int64Field(12, int64(p.interval))
+	for _, s := range strs {
+		prof.stringField(6, s)
+	}
+	prof.data = append(prof.data, rest.data...)
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(prof.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+//protoBuffer encodes the handful of protocol buffer wire types a pprof profile needs
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+//wire types are 0 for varints and 2 for anything with a length
+func (b *protoBuffer) tag(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) uint64Field(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.tag(field, 0)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64Field(field int, x int64) {
+	b.uint64Field(field, uint64(x))
+}
+
+//strings are always written, since the first entry of the string table has to be the empty string
+func (b *protoBuffer) stringField(field int, s string) {
+	b.tag(field, 2)
+	b.varint(uint64(len(s)))
+	b.data = append(b.data, s...)
+}
+
+func (b *protoBuffer) message(field int, m *protoBuffer) {
+	b.tag(field, 2)
+	b.varint(uint64(len(m.data)))
+	b.data = append(b.data, m.data...)
+}
+
+func (b *protoBuffer) packedField(field int, xs []uint64) {
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.message(field, &packed)
+}
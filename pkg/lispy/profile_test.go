@@ -0,0 +1,88 @@
+package lispy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfiler(t *testing.T) {
+	exprs, err := Parse(Read(strings.NewReader(`(define count-down [n] (if (= n 0) 0 (count-down (- n 1))))
+(define fact [n] (if (= n 0) 1 (* n (fact (- n 1)))))
+(count-down 100)
+(fact 5)`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewProfiler(time.Millisecond)
+	env := InitState()
+	env.SetProfiler(p)
+	p.Start()
+	_, err = env.TryEval(WithFile(exprs, "prof.lpy"))
+	p.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//tail calls unwound by unwrapThunks are counted like any other
+	entries, _ := p.entries()
+	calls := make(map[string]int)
+	for _, e := range entries {
+		calls[e.function.String()] = e.calls
+	}
+	if calls["count-down prof.lpy:1"] != 101 || calls["fact prof.lpy:2"] != 6 {
+		t.Errorf("expected 101 calls to count-down and 6 to fact, got %v", calls)
+	}
+	if len(p.stack.funcs) != 0 {
+		t.Errorf("expected the stack to be empty after the program, got %v", p.stack.funcs)
+	}
+	var report bytes.Buffer
+	if err := p.WriteReport(&report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "fact prof.lpy:2") {
+		t.Errorf("expected fact in the report, got\n%s", report.String())
+	}
+	var profile bytes.Buffer
+	if err := p.WriteProfile(&profile); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the program may well finish before it's sampled, but the sample types are always there
+	if !bytes.Contains(data, []byte("samples")) || !bytes.Contains(data, []byte("nanoseconds")) {
+		t.Errorf("expected the profile to have the sample types in its string table")
+	}
+}
+
+//spawned goroutines are profiled with a stack of their own, which is dropped once they finish
+func TestProfilerSpawn(t *testing.T) {
+	exprs, err := Parse(Read(strings.NewReader(`(define count-down [n] (if (= n 0) 0 (count-down (- n 1))))
+(recv! (spawn count-down 10))`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewProfiler(time.Millisecond)
+	env := InitState()
+	env.SetProfiler(p)
+	p.Start()
+	_, err = env.TryEval(WithFile(exprs, "spawn.lpy"))
+	p.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.calls[profileFunc{name: "count-down", file: "spawn.lpy", line: 1}] != 11 {
+		t.Errorf("expected 11 calls to count-down in the spawned goroutine, got %v", p.calls)
+	}
+	if len(p.stacks) != 1 {
+		t.Errorf("expected only the main goroutine's stack once the spawned one finished, got %d stacks", len(p.stacks))
+	}
+}
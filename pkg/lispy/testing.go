@@ -0,0 +1,271 @@
+package lispy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+//tests keeps track of the tests defined with deftest and the results of the one running
+//it is shared by every environment created from the same InitState
+type tests struct {
+	mu      sync.Mutex
+	defined []testDefinition
+	//fixtures wrapping each test, and the whole run
+	each []FunctionValue
+	once []FunctionValue
+	//set while a test is running
+	current *TestResult
+	//descriptions of the testing forms being evaluated, outermost first
+	contexts []string
+}
+
+type testDefinition struct {
+	name string
+	body []Sexp
+}
+
+//TestResult is the outcome of running one test defined with deftest
+type TestResult struct {
+	Name string
+	//number of is and assert= checks made
+	Assertions int
+	Failures   []TestFailure
+	//Err is set if the test stopped early because of an error
+	Err      error
+	Duration time.Duration
+}
+
+//Passed reports whether the test finished without any failed checks
+func (r TestResult) Passed() bool {
+	return r.Err == nil && len(r.Failures) == 0
+}
+
+//TestFailure describes a check that failed
+type TestFailure struct {
+	//descriptions of the testing forms the check was in, outermost first
+	Context []string
+	//the check that failed e.g. (is (= 4 (+ 2 3)))
+	Form    string
+	Message string
+	//for comparisons, the values compared, printed with Pretty
+	Expected string
+	Actual   string
+}
+
+//RunTests runs every test defined with deftest whose name is accepted by match (or every test, if match is nil)
+//in the order they were defined, each in its own copy of env so definitions made by one test don't leak into the next
+//the error is only set if a fixture registered with (use-fixtures :once f) fails
+func (env *Env) RunTests(match func(name string) bool) ([]TestResult, error) {
+	t := env.tests
+	t.mu.Lock()
+	defined := append([]testDefinition{}, t.defined...)
+	once := append([]FunctionValue{}, t.once...)
+	t.mu.Unlock()
+	results := make([]TestResult, 0)
+	err := env.try(func() {
+		withFixtures(env, once, func(*Env) {
+			for _, test := range defined {
+				if match == nil || match(test.name) {
+					results = append(results, env.runTest(test))
+				}
+			}
+		})
+	})
+	return results, err
+}
+
+func (env *Env) runTest(test testDefinition) TestResult {
+	t := env.tests
+	result := &TestResult{Name: test.name}
+	t.mu.Lock()
+	t.current = result
+	t.contexts = nil
+	each := append([]FunctionValue{}, t.each...)
+	t.mu.Unlock()
+	start := time.Now()
+	testEnv := newFunctionEnv(env)
+	result.Err = env.try(func() {
+		withFixtures(testEnv, each, func(bodyEnv *Env) {
+			for _, expr := range test.body {
+				expr.Eval(bodyEnv, &StackFrame{}, false)
+			}
+		})
+	})
+	result.Duration = time.Since(start)
+	t.mu.Lock()
+	t.current = nil
+	t.mu.Unlock()
+	return *result
+}
+
+//runs run inside every fixture, a fixture is a function taking a function which runs whatever it wraps
+//run is passed the environment it was called from so (since scope is dynamic) it sees whatever the fixtures defined
+func withFixtures(env *Env, fixtures []FunctionValue, run func(env *Env)) {
+	if len(fixtures) == 0 {
+		run(env)
+		return
+	}
+	next := makeUserFunction("run", func(env *Env, name string, args []Sexp) Sexp {
+		withFixtures(env, fixtures[1:], run)
+		return SexpSymbol{ofType: FALSE, value: "nil"}
+	})
+	callFunction(env, fixtures[0], []Sexp{next})
+}
+
+//records the result of a check in the test being run, a failure outside of a test is reported on the error stream
+func (env *Env) recordCheck(passed bool, failure TestFailure) {
+	t := env.tests
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	failure.Context = append([]string{}, t.contexts...)
+	if t.current == nil {
+		if !passed {
+			fmt.Fprintln(env.ports.err, "FAIL", failure.Form)
+		}
+		return
+	}
+	t.current.Assertions++
+	if !passed {
+		t.current.Failures = append(t.current.Failures, failure)
+	}
+}
+
+/******* deftest *********/
+//(deftest name body...) defines a test which is run by lispy test (or RunTests), redefining a test replaces it
+func deftest(env *Env, name string, args []Sexp) Sexp {
+	testArgs, isList := args[0].(SexpPair)
+	if !isList || testArgs.head == nil {
+		fatal("Error, deftest requires the name of the test")
+	}
+	terms := makeList(testArgs)
+	test := testDefinition{name: terms[0].String(), body: terms[1:]}
+	t := env.tests
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, defined := range t.defined {
+		if defined.name == test.name {
+			t.defined[i] = test
+			return terms[0]
+		}
+	}
+	t.defined = append(t.defined, test)
+	return terms[0]
+}
+
+//(testing "description" body...) evaluates body, adding description to any failures in it
+func testingStatement(env *Env, name string, args []Sexp) Sexp {
+	testingArgs, isList := args[0].(SexpPair)
+	if !isList || testingArgs.head == nil {
+		fatal("Error, testing requires a description")
+	}
+	terms := makeList(testingArgs)
+	description := terms[0].Eval(env, &StackFrame{}, false).String()
+	t := env.tests
+	t.mu.Lock()
+	t.contexts = append(t.contexts, description)
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.contexts = t.contexts[:len(t.contexts)-1]
+		t.mu.Unlock()
+	}()
+	var res Sexp = SexpSymbol{ofType: FALSE, value: "nil"}
+	for _, expr := range terms[1:] {
+		res = expr.Eval(env, &StackFrame{}, false)
+	}
+	return res
+}
+
+//(is expr) checks expr is true, (is expr "message") adds a message to the failure
+//for (is (= expected actual)) the failure shows both values
+func isStatement(env *Env, name string, args []Sexp) Sexp {
+	isArgs, isList := args[0].(SexpPair)
+	if !isList || isArgs.head == nil {
+		fatal("Error, is requires an expression to check")
+	}
+	terms := makeList(isArgs)
+	if len(terms) > 2 {
+		fatal("Error, is takes an expression and optionally a message")
+	}
+	form := terms[0]
+	failure := TestFailure{Form: "(is " + readableString(form) + ")"}
+	if len(terms) == 2 {
+		failure.Message = terms[1].Eval(env, &StackFrame{}, false).String()
+	}
+	var passed bool
+	if comparison, isPair := form.(SexpPair); isPair && isEqualsForm(comparison) {
+		operands := makeList(comparison.tail.(SexpPair))
+		expected := nilIfEmpty(operands[0].Eval(env, &StackFrame{}, false))
+		actual := nilIfEmpty(operands[1].Eval(env, &StackFrame{}, false))
+		passed = isEqual(env, expected, actual)
+		failure.Expected = Pretty(expected, defaultPrettyWidth)
+		failure.Actual = Pretty(actual, defaultPrettyWidth)
+	} else {
+		passed = getBoolFromTokenType(nilIfEmpty(form.Eval(env, &StackFrame{}, false)))
+	}
+	env.recordCheck(passed, failure)
+	return getSexpSymbolFromBool(passed)
+}
+
+//checks for (= a b)
+func isEqualsForm(form SexpPair) bool {
+	op, isSymbol := form.head.(SexpSymbol)
+	if !isSymbol || op.value != "=" {
+		return false
+	}
+	operands, isList := form.tail.(SexpPair)
+	return isList && len(makeList(operands)) == 2
+}
+
+//(assert= expected actual) checks two values are equal, (assert= expected actual "message") adds a message to the failure
+func assertEqual(env *Env, name string, args []Sexp) Sexp {
+	if len(args) != 2 && len(args) != 3 {
+		fatal("Error, ", name, " takes the expected value, the actual value and optionally a message")
+	}
+	expected, actual := nilIfEmpty(args[0]), nilIfEmpty(args[1])
+	failure := TestFailure{
+		Form:     "(" + name + " " + readableString(expected) + " " + readableString(actual) + ")",
+		Expected: Pretty(expected, defaultPrettyWidth),
+		Actual:   Pretty(actual, defaultPrettyWidth),
+	}
+	if len(args) == 3 {
+		failure.Message = args[2].String()
+	}
+	passed := isEqual(env, expected, actual)
+	env.recordCheck(passed, failure)
+	return getSexpSymbolFromBool(passed)
+}
+
+//(use-fixtures :each f) wraps every test in f, (use-fixtures :once f) wraps the whole run
+//f is called with a function which runs the test(s), e.g. (fn [run] (do (setup) (run) (teardown)))
+func useFixtures(env *Env, name string, args []Sexp) Sexp {
+	fixtureArgs, isList := args[0].(SexpPair)
+	if !isList || fixtureArgs.head == nil {
+		fatal("Error, use-fixtures requires :each or :once and the fixtures to use")
+	}
+	terms := makeList(fixtureArgs)
+	fixtures := make([]FunctionValue, 0)
+	for _, term := range terms[1:] {
+		fixture, isFunc := term.Eval(env, &StackFrame{}, false).(FunctionValue)
+		if !isFunc {
+			fatal("Error, fixtures must be functions")
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	t := env.tests
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch terms[0].String() {
+	case ":each":
+		t.each = append(t.each, fixtures...)
+	case ":once":
+		t.once = append(t.once, fixtures...)
+	default:
+		fatal("Error, use-fixtures expects :each or :once but got ", terms[0])
+	}
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
@@ -0,0 +1,147 @@
+package lispy
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+//tracer logs calls to traced functions along with their results, indented by how deeply they're nested,
+//and the expansions of traced macros. What is traced is shared by every environment created from the same InitState,
+//but each goroutine keeps its own depth so calls in spawned goroutines don't change the indentation of the spawner's
+type tracer struct {
+	*traceSettings
+	depth int
+}
+
+type traceSettings struct {
+	mu sync.Mutex
+	//where to log, the error stream if nil
+	out   io.Writer
+	all   bool
+	names map[string]bool
+}
+
+//SetTraceOutput changes where traced calls are logged, by default they're written to the error stream
+func (env *Env) SetTraceOutput(w io.Writer) {
+	env.trace.mu.Lock()
+	defer env.trace.mu.Unlock()
+	env.trace.out = w
+}
+
+//Trace logs every call to the named functions (and expansion of the named macros), or everything if no names are given
+func (env *Env) Trace(names ...string) {
+	t := env.trace
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(names) == 0 {
+		t.all = true
+	}
+	for _, name := range names {
+		t.names[name] = true
+	}
+}
+
+//Untrace stops tracing the named functions, or everything if no names are given
+func (env *Env) Untrace(names ...string) {
+	t := env.trace
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(names) == 0 {
+		t.all = false
+		t.names = make(map[string]bool)
+	}
+	for _, name := range names {
+		delete(t.names, name)
+	}
+}
+
+func newTracer() *tracer {
+	return &tracer{traceSettings: &traceSettings{names: make(map[string]bool)}}
+}
+
+//a tracer for a spawned goroutine, which traces the same functions starting from the spawner's current depth
+func (t *tracer) fork() *tracer {
+	return &tracer{traceSettings: t.traceSettings, depth: t.depth}
+}
+
+func (t *tracer) traces(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.all || t.names[name]
+}
+
+//writes line indented by the current depth
+func (t *tracer) log(env *Env, line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := t.out
+	if out == nil {
+		out = env.ports.err
+	}
+	fmt.Fprintln(out, strings.Repeat("  ", t.depth)+line)
+}
+
+//logs a traced call with its arguments and runs it, its result is logged once it returns which for a call in tail
+//position is when the thunk returned for tail call optimization is unwound
+func (t *tracer) call(env *Env, node FunctionValue, name string, args []Sexp, allowThunk bool) Sexp {
+	t.log(env, readableString(makeSList(append([]Sexp{SexpSymbol{ofType: SYMBOL, value: name}}, args...))))
+	t.depth++
+	res := applyFunction(env, node, name, args, true)
+	thunk, isThunk := res.(FunctionThunkValue)
+	if !isThunk {
+		//builtins return their result straight away
+		t.returned(env, res)
+		return res
+	}
+	thunk.traced = true
+	if allowThunk {
+		return thunk
+	}
+	return unwrapThunks(thunk)
+}
+
+//logs the result of a traced call
+func (t *tracer) returned(env *Env, res Sexp) {
+	t.depth--
+	t.log(env, "=> "+readableString(nilIfEmpty(res)))
+}
+
+//logs what a traced macro call expanded to
+func (t *tracer) expansion(env *Env, name string, args SexpPair, expanded Sexp) {
+	call := SexpPair{head: SexpSymbol{ofType: SYMBOL, value: name}, tail: args}
+	if args.head == nil {
+		call.tail = nil
+	}
+	t.log(env, readableString(call)+" ~> "+readableString(nilIfEmpty(expanded)))
+}
+
+//returns the names of the functions passed to trace or untrace, which can be given as functions or symbols
+func traceNames(name string, args []Sexp) []string {
+	names := make([]string, 0)
+	for _, arg := range args {
+		switch i := arg.(type) {
+		case FunctionValue:
+			names = append(names, i.defn.name)
+		case SexpSymbol:
+			names = append(names, i.value)
+		default:
+			fatal("Error, ", name, " expects functions or their names but got ", arg)
+		}
+	}
+	return names
+}
+
+/******* trace *********/
+//(trace f g) logs calls to f and g, (trace) logs every call and macro expansion
+func trace(env *Env, name string, args []Sexp) Sexp {
+	env.Trace(traceNames(name, args)...)
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
+
+//(untrace f) stops logging calls to f, (untrace) stops tracing altogether
+func untrace(env *Env, name string, args []Sexp) Sexp {
+	env.Untrace(traceNames(name, args)...)
+	return SexpSymbol{ofType: FALSE, value: "nil"}
+}
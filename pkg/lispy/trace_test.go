@@ -0,0 +1,114 @@
+package lispy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTraceOutput(t *testing.T) {
+	exprs, err := Parse(Read(strings.NewReader(`(define fact [n] (if (= n 0) 1 (* n (fact (- n 1)))))
+(fact 2)
+(when true 1)`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace, errors bytes.Buffer
+	env := InitState()
+	env.SetError(&errors)
+	env.SetTraceOutput(&trace)
+	env.Trace("fact", "when")
+	if _, err := env.TryEval(exprs); err != nil {
+		t.Fatal(err)
+	}
+	want := "(fact 2)\n  (fact 1)\n    (fact 0)\n    => 1\n  => 1\n=> 2\n(when true 1) ~> (if true 1)\n"
+	if trace.String() != want {
+		t.Errorf("traced\n%s\nexpected\n%s", trace.String(), want)
+	}
+	if errors.Len() != 0 {
+		t.Errorf("expected nothing on the error stream when tracing to a writer, got %q", errors.String())
+	}
+}
+
+//traced calls in tail position still return a thunk, and log their result once it's unwound
+func TestTraceTailCalls(t *testing.T) {
+	exprs, err := Parse(Read(strings.NewReader(`(define count [n] (if (= n 0) "done" (count (- n 1))))
+(count 2)`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace bytes.Buffer
+	env := InitState()
+	env.SetTraceOutput(&trace)
+	env.Trace("count")
+	if _, err := env.TryEval(exprs); err != nil {
+		t.Fatal(err)
+	}
+	want := "(count 2)\n  (count 1)\n    (count 0)\n    => \"done\"\n  => \"done\"\n=> \"done\"\n"
+	if trace.String() != want {
+		t.Errorf("traced\n%s\nexpected\n%s", trace.String(), want)
+	}
+	count := env.store["count"].(FunctionValue)
+	res := env.trace.call(newFunctionEnv(env), count, "count", []Sexp{SexpInt(1)}, true)
+	thunk, isThunk := res.(FunctionThunkValue)
+	if !isThunk {
+		t.Fatalf("traced call in tail position returned %v rather than a thunk", res)
+	}
+	unwrapThunks(thunk)
+	if env.trace.depth != 0 {
+		t.Errorf("expected the depth to be back to 0 once the thunk was unwound, got %d", env.trace.depth)
+	}
+}
+
+//calls in a spawned goroutine are indented from where they were spawned, without changing the spawner's depth
+func TestTraceSpawn(t *testing.T) {
+	exprs, err := Parse(Read(strings.NewReader(`(define f [x] (inc x))
+(define g [] (recv! (spawn (fn [] (f 1)))))
+(g)
+(f 2)`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace bytes.Buffer
+	env := InitState()
+	env.SetTraceOutput(&trace)
+	env.Trace("f", "g")
+	if _, err := env.TryEval(exprs); err != nil {
+		t.Fatal(err)
+	}
+	want := "(g)\n  (f 1)\n  => 2\n=> 2\n(f 2)\n=> 3\n"
+	if trace.String() != want {
+		t.Errorf("traced\n%s\nexpected\n%s", trace.String(), want)
+	}
+}
+
+//functions from a module are traced with the caller's tracer, so their calls are nested under the caller's even in a
+//spawned goroutine
+func TestTraceModuleFunction(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "util.lpy"), []byte("(define twice [x] (* 2 x))\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exprs, err := Parse(Read(strings.NewReader(`(require 'util :refer [twice])
+(define g [] (twice 1))
+(define h [] (recv! (spawn (fn [] (g)))))
+(h)
+(map (list (spawn g) (spawn g)) recv!)`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace bytes.Buffer
+	env := InitState()
+	env.SetModulePath(dir)
+	env.SetTraceOutput(&trace)
+	env.Trace("g", "h", "twice")
+	if _, err := env.TryEval(exprs); err != nil {
+		t.Fatal(err)
+	}
+	want := "(h)\n  (g)\n    (twice 1)\n    => 2\n  => 2\n=> 2\n"
+	if !strings.HasPrefix(trace.String(), want) {
+		t.Errorf("traced\n%s\nexpected it to start with\n%s", trace.String(), want)
+	}
+}
@@ -0,0 +1,240 @@
+package lsp
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/amirgamil/lispy/pkg/lispy"
+)
+
+//a document open in the editor, analysed from its raw tokens (which keep the exact source text, so their offsets can
+//be found) rather than from Parse, since it is usually half written and the definitions in it are wanted regardless
+type document struct {
+	uri  string
+	text string
+	//byte offset each line starts at
+	lines  []int
+	tokens []token
+	//every define and macro, in the order they appear
+	definitions []definition
+}
+
+type token struct {
+	lispy.Token
+	//byte offsets of the token in the text
+	start, end int
+}
+
+type definition struct {
+	name  string
+	macro bool
+	//the parameters of a function or macro as written e.g. [x & rest], one for each parameter list of a function with
+	//several and none for a variable
+	params []string
+	doc    string
+	//the token naming it, and the tokens of the whole form
+	nameTok    token
+	start, end int
+}
+
+func (d definition) isFunction() bool {
+	return d.macro || len(d.params) > 0
+}
+
+//e.g. (f x & rest) for a function, (f x) or (f x y) for one with several parameter lists, or just the name for a variable
+func (d definition) signature() string {
+	if !d.isFunction() {
+		return d.name
+	}
+	signatures := make([]string, 0)
+	for _, params := range d.params {
+		params = strings.TrimSuffix(strings.TrimPrefix(params, "["), "]")
+		if params == "" {
+			signatures = append(signatures, "("+d.name+")")
+		} else {
+			signatures = append(signatures, "("+d.name+" "+params+")")
+		}
+	}
+	if len(signatures) == 0 {
+		return "(" + d.name + ")"
+	}
+	return strings.Join(signatures, " or ")
+}
+
+func (d definition) kind() string {
+	switch {
+	case d.macro:
+		return "macro"
+	case d.isFunction():
+		return "function"
+	}
+	return "variable"
+}
+
+func newDocument(uri string, text string) *document {
+	doc := &document{uri: uri, text: text, lines: []int{0}}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			doc.lines = append(doc.lines, i+1)
+		}
+	}
+	pos := 0
+	for _, tok := range lispy.ReadRaw(strings.NewReader(text)) {
+		//raw literals are exactly the text they were read from, so each is the next occurrence of it in the source
+		idx := strings.Index(text[pos:], tok.Literal)
+		if idx < 0 {
+			break
+		}
+		start := pos + idx
+		pos = start + len(tok.Literal)
+		doc.tokens = append(doc.tokens, token{Token: tok, start: start, end: pos})
+	}
+	doc.findDefinitions()
+	return doc
+}
+
+func (doc *document) findDefinitions() {
+	for i := 0; i+2 < len(doc.tokens); i++ {
+		open, keyword, name := doc.tokens[i], doc.tokens[i+1], doc.tokens[i+2]
+		if open.Token.Token != lispy.LPAREN || name.Token.Token != lispy.SYMBOL ||
+			(keyword.Token.Token != lispy.DEFINE && keyword.Token.Token != lispy.MACRO) {
+			continue
+		}
+		defn := definition{name: name.Literal, macro: keyword.Token.Token == lispy.MACRO, nameTok: name, start: open.start}
+		defn.end = doc.tokens[doc.closing(i)].end
+		params := i + 3
+		if params+1 < len(doc.tokens) && doc.tokens[params].Token.Token == lispy.STRING && doc.startsParams(params+1) {
+			//raw literals keep their quotes and escapes, reading them again gives the string itself
+			defn.doc = lispy.Read(strings.NewReader(doc.tokens[params].Literal))[0].Literal
+			params++
+		}
+		if params < len(doc.tokens) && doc.tokens[params].Token.Token == lispy.LSQUARE {
+			defn.params = []string{doc.text[doc.tokens[params].start:doc.tokens[doc.closing(params)].end]}
+		} else {
+			//several parameter lists, each in a list with its body e.g. ([x] x) ([x y] (+ x y))
+			for params < len(doc.tokens) && doc.tokens[params].Token.Token == lispy.LPAREN && doc.startsParams(params) {
+				defn.params = append(defn.params, doc.text[doc.tokens[params+1].start:doc.tokens[doc.closing(params+1)].end])
+				params = doc.closing(params) + 1
+			}
+		}
+		doc.definitions = append(doc.definitions, defn)
+	}
+}
+
+//whether the tokens at idx start the parameters of a function, either [params] or ([params] body)
+func (doc *document) startsParams(idx int) bool {
+	switch {
+	case idx >= len(doc.tokens):
+		return false
+	case doc.tokens[idx].Token.Token == lispy.LSQUARE:
+		return true
+	}
+	return doc.tokens[idx].Token.Token == lispy.LPAREN && idx+1 < len(doc.tokens) && doc.tokens[idx+1].Token.Token == lispy.LSQUARE
+}
+
+//the index of the token closing the list or array opened at idx, or the last token if it is never closed
+func (doc *document) closing(idx int) int {
+	depth := 0
+	for i := idx; i < len(doc.tokens); i++ {
+		switch doc.tokens[i].Token.Token {
+		case lispy.LPAREN, lispy.LSQUARE:
+			depth++
+		case lispy.RPAREN, lispy.RSQUARE:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(doc.tokens) - 1
+}
+
+//the symbol the position is on (or just after, since that's where the cursor is when a word has been typed)
+func (doc *document) symbolAt(pos position) (token, bool) {
+	offset := doc.offset(pos)
+	for _, tok := range doc.tokens {
+		if tok.start <= offset && offset <= tok.end && isSymbol(tok) {
+			return tok, true
+		}
+	}
+	return token{}, false
+}
+
+func isSymbol(tok token) bool {
+	switch tok.Token.Token {
+	case lispy.SYMBOL, lispy.DEFINE, lispy.MACRO, lispy.IF, lispy.DO:
+		return true
+	}
+	return false
+}
+
+//the part of a symbol before the position, which is what completions have to start with
+func (doc *document) prefixAt(pos position) string {
+	offset := doc.offset(pos)
+	start := offset
+	for start > 0 && !strings.ContainsRune(" \t\r\n()[]'@\"", rune(doc.text[start-1])) {
+		start--
+	}
+	return doc.text[start:offset]
+}
+
+//the definitions of name, in the order they appear
+func (doc *document) lookup(name string) []definition {
+	found := make([]definition, 0)
+	for _, defn := range doc.definitions {
+		if defn.name == name {
+			found = append(found, defn)
+		}
+	}
+	return found
+}
+
+//converts a byte offset to a position, counting characters in UTF-16 code units as the protocol does
+func (doc *document) position(offset int) position {
+	line := sort.Search(len(doc.lines), func(i int) bool { return doc.lines[i] > offset }) - 1
+	return position{Line: line, Character: utf16Length(doc.text[doc.lines[line]:offset])}
+}
+
+//converts a position to a byte offset, clamping it to the document
+func (doc *document) offset(pos position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(doc.lines) {
+		return len(doc.text)
+	}
+	offset := doc.lines[pos.Line]
+	for units := 0; units < pos.Character && offset < len(doc.text) && doc.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(doc.text[offset:])
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+func (doc *document) textRange(start int, end int) textRange {
+	return textRange{Start: doc.position(start), End: doc.position(end)}
+}
+
+//the range of a line (counting from 1 like the interpreter does) without its indentation
+func (doc *document) lineRange(line int) textRange {
+	if line < 1 {
+		line = 1
+	}
+	if line > len(doc.lines) {
+		line = len(doc.lines)
+	}
+	start, end := doc.lines[line-1], len(doc.text)
+	if line < len(doc.lines) {
+		end = doc.lines[line] - 1
+	}
+	text := doc.text[start:end]
+	indent := len(text) - len(strings.TrimLeft(text, " \t"))
+	return doc.textRange(start+indent, start+len(strings.TrimRight(text, " \t\r")))
+}
+
+func utf16Length(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
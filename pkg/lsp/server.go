@@ -0,0 +1,407 @@
+//Package lsp is a Language Server Protocol server for Lispy, which gives editors diagnostics, hover documentation,
+//go to definition, completion and document symbols for .lpy files
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amirgamil/lispy/pkg/lispy"
+)
+
+//Server answers the requests of one editor, reading messages from in and writing replies to out
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+	//the builtins and library, which is what hover and completion know about besides the open documents
+	prelude *lispy.Env
+	//open documents by URI
+	documents map[string]*document
+	//set once the client has asked the server to shut down, after which it should only send exit
+	shutdown bool
+}
+
+//NewServer returns a server which talks to an editor over in and out, usually stdin and stdout
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, prelude: lispy.InitState(), documents: make(map[string]*document)}
+}
+
+//names the evaluator gives meaning to without binding them, which are completed but have no documentation
+var keywords = []string{"define", "macro", "fn", "if", "do", "quote", "swap", "select", "ns", "require",
+	"deftest", "testing", "is", "use-fixtures", "let", "let*", "letrec", "true", "false", "nil"}
+
+//Serve handles messages until the client sends exit or closes the connection
+func (s *Server) Serve() error {
+	for {
+		body, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.writeError(json.RawMessage("null"), parseErrorCode, err.Error())
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exited without being shut down")
+			}
+			return nil
+		}
+		result, err := s.handle(req)
+		if req.ID == nil {
+			//notifications don't get a reply, even if they fail
+			continue
+		}
+		if respErr, isRespErr := err.(*responseError); isRespErr {
+			s.writeError(*req.ID, respErr.Code, respErr.Message)
+		} else if err != nil {
+			s.writeError(*req.ID, internalErrorCode, err.Error())
+		} else {
+			s.write(response{JSONRPC: "2.0", ID: *req.ID, Result: result})
+		}
+	}
+}
+
+//messages are a JSON body preceded by headers, of which only Content-Length matters
+func (s *Server) readMessage() ([]byte, error) {
+	headers, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF || len(headers) == 0 && errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length header %q", headers.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (s *Server) write(msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = s.out.Write(body)
+	return err
+}
+
+func (s *Server) writeError(id json.RawMessage, code int, msg string) {
+	s.write(errorResponse{JSONRPC: "2.0", ID: id, Error: responseError{Code: code, Message: msg}})
+}
+
+//handles a request or notification, returning the result to reply with
+func (s *Server) handle(req request) (result interface{}, err error) {
+	//Lispy reports errors by panicking, which shouldn't take the whole server down
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("%s failed: %v", req.Method, r)
+		}
+	}()
+	switch req.Method {
+	case "initialize":
+		return initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:       1,
+				HoverProvider:          true,
+				DefinitionProvider:     true,
+				CompletionProvider:     completionOptions{TriggerCharacters: []string{"("}},
+				DocumentSymbolProvider: true,
+			},
+			ServerInfo: serverInfo{Name: "lispy"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.open(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		//documents are synced in full, so the last change is the whole new text
+		return nil, s.open(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		//clear the diagnostics of the closed document
+		return nil, s.publishDiagnostics(params.TextDocument.URI, []diagnostic{})
+	case "textDocument/hover":
+		doc, pos, err := s.positionParams(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.hover(doc, pos), nil
+	case "textDocument/definition":
+		doc, pos, err := s.positionParams(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.definition(doc, pos), nil
+	case "textDocument/completion":
+		doc, pos, err := s.positionParams(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.completion(doc, pos), nil
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, found := s.documents[params.TextDocument.URI]
+		if !found {
+			return nil, fmt.Errorf("%s isn't open", params.TextDocument.URI)
+		}
+		return documentSymbols(doc), nil
+	}
+	//notifications the server doesn't know about, like $/cancelRequest, are ignored since they don't get a reply
+	return nil, &responseError{Code: methodNotFoundCode, Message: req.Method + " is not supported"}
+}
+
+func unmarshal(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{Code: invalidParamsCode, Message: "invalid params: " + err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) positionParams(params json.RawMessage) (*document, position, error) {
+	var p textDocumentPositionParams
+	if err := unmarshal(params, &p); err != nil {
+		return nil, position{}, err
+	}
+	doc, found := s.documents[p.TextDocument.URI]
+	if !found {
+		return nil, position{}, fmt.Errorf("%s isn't open", p.TextDocument.URI)
+	}
+	return doc, p.Position, nil
+}
+
+//records the text of a document and reports what's wrong with it
+func (s *Server) open(uri string, text string) error {
+	doc := newDocument(uri, text)
+	s.documents[uri] = doc
+	return s.publishDiagnostics(uri, diagnostics(doc))
+}
+
+func (s *Server) publishDiagnostics(uri string, diagnostics []diagnostic) error {
+	return s.write(notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+//a parse error if the document doesn't parse, otherwise whatever lispy check finds
+func diagnostics(doc *document) []diagnostic {
+	found := make([]diagnostic, 0)
+	checked, err := lispy.Check(doc.text)
+	if err != nil {
+		line := 0
+		if parseErr, isParseErr := err.(*lispy.ParseError); isParseErr {
+			line = parseErr.Line
+			err = errors.New(parseErr.Msg)
+		}
+		return append(found, diagnostic{Range: doc.lineRange(line), Severity: severityError, Source: "lispy", Message: err.Error()})
+	}
+	for _, d := range checked {
+		found = append(found, diagnostic{Range: doc.lineRange(d.Line), Severity: severityWarning, Source: "lispy check", Message: d.Msg})
+	}
+	return found
+}
+
+//definitions of name in the open documents, starting with doc
+func (s *Server) definitions(doc *document, name string) []*definitionIn {
+	found := make([]*definitionIn, 0)
+	for _, defn := range doc.lookup(name) {
+		found = append(found, &definitionIn{defn, doc})
+	}
+	uris := make([]string, 0, len(s.documents))
+	for uri := range s.documents {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		if uri == doc.uri {
+			continue
+		}
+		other := s.documents[uri]
+		for _, defn := range other.lookup(name) {
+			found = append(found, &definitionIn{defn, other})
+		}
+	}
+	return found
+}
+
+type definitionIn struct {
+	definition
+	in *document
+}
+
+func (d *definitionIn) location() location {
+	return location{URI: d.in.uri, Range: d.in.textRange(d.nameTok.start, d.nameTok.end)}
+}
+
+//the signature and kind of the symbol under the cursor, or nil if it isn't defined anywhere the server can see
+func (s *Server) hover(doc *document, pos position) interface{} {
+	tok, found := s.symbolAt(doc, pos)
+	if !found {
+		return nil
+	}
+	var signature, detail string
+	if defns := s.definitions(doc, tok.Literal); len(defns) > 0 {
+		defn := defns[0]
+		signature = defn.signature()
+		detail = fmt.Sprintf("%s defined on line %d", defn.kind(), defn.nameTok.Line)
+		if defn.in != doc {
+			detail += " of " + defn.in.uri
+		}
+		if defn.doc != "" {
+			detail += "\n\n" + defn.doc
+		}
+	} else {
+		description, err := s.prelude.Doc(tok.Literal)
+		if err != nil {
+			return nil
+		}
+		lines := strings.Split(description, "\n")
+		signature = lines[0]
+		for i := range lines[1:] {
+			lines[i+1] = strings.TrimSpace(lines[i+1])
+		}
+		//separate paragraphs, since markdown would run the lines together
+		detail = strings.Join(lines[1:], "\n\n")
+	}
+	return hover{
+		Contents: markupContent{Kind: "markdown", Value: "```lispy\n" + signature + "\n```\n" + detail},
+		Range:    doc.textRange(tok.start, tok.end),
+	}
+}
+
+func (s *Server) symbolAt(doc *document, pos position) (token, bool) {
+	tok, found := doc.symbolAt(pos)
+	if !found || tok.Token.Token != lispy.SYMBOL {
+		return token{}, false
+	}
+	return tok, true
+}
+
+func (s *Server) definition(doc *document, pos position) []location {
+	locations := make([]location, 0)
+	tok, found := s.symbolAt(doc, pos)
+	if !found {
+		return locations
+	}
+	for _, defn := range s.definitions(doc, tok.Literal) {
+		locations = append(locations, defn.location())
+	}
+	return locations
+}
+
+//everything defined in the open documents and the prelude, along with the special forms, which starts with what has
+//been typed of the symbol at the cursor
+func (s *Server) completion(doc *document, pos position) []completionItem {
+	prefix := doc.prefixAt(pos)
+	items := make([]completionItem, 0)
+	seen := make(map[string]bool)
+	add := func(item completionItem) {
+		if !seen[item.Label] && strings.HasPrefix(item.Label, prefix) {
+			items = append(items, item)
+			seen[item.Label] = true
+		}
+	}
+	docs := []*document{doc}
+	for _, other := range s.documents {
+		if other != doc {
+			docs = append(docs, other)
+		}
+	}
+	for _, d := range docs {
+		for _, defn := range d.definitions {
+			kind := completionVariable
+			if defn.isFunction() {
+				kind = completionFunction
+			}
+			add(completionItem{Label: defn.name, Kind: kind, Detail: defn.signature()})
+		}
+	}
+	for _, name := range s.prelude.Bindings() {
+		description, _ := s.prelude.Doc(name)
+		kind := completionVariable
+		if strings.HasPrefix(description, "(") {
+			kind = completionFunction
+		}
+		add(completionItem{Label: name, Kind: kind, Detail: strings.SplitN(description, "\n", 2)[0]})
+	}
+	for _, keyword := range keywords {
+		add(completionItem{Label: keyword, Kind: completionKeyword})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items
+}
+
+//the definitions in a document, with those made inside another (e.g. a define in the body of a function) as its children
+func documentSymbols(doc *document) []documentSymbol {
+	var build func(defns []definition) []documentSymbol
+	build = func(defns []definition) []documentSymbol {
+		symbols := make([]documentSymbol, 0)
+		for len(defns) > 0 {
+			defn := defns[0]
+			//definitions are in order, so the ones inside this one are those which start before it ends
+			inside := 1
+			for inside < len(defns) && defns[inside].start < defn.end {
+				inside++
+			}
+			children := build(defns[1:inside])
+			defns = defns[inside:]
+			kind := symbolVariable
+			if defn.isFunction() {
+				kind = symbolFunction
+			}
+			symbols = append(symbols, documentSymbol{
+				Name:           defn.name,
+				Detail:         defn.signature(),
+				Kind:           kind,
+				Range:          doc.textRange(defn.start, defn.end),
+				SelectionRange: doc.textRange(defn.nameTok.start, defn.nameTok.end),
+				Children:       children,
+			})
+		}
+		return symbols
+	}
+	return build(doc.definitions)
+}
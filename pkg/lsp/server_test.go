@@ -0,0 +1,243 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//client plays the part of an editor, sending requests to a server running in the background
+type client struct {
+	t  *testing.T
+	in *io.PipeWriter
+	//messages from the server, read in the background since it can send notifications at any time
+	messages chan map[string]json.RawMessage
+	nextID   int
+	//notifications received while waiting for responses
+	notifications []request
+	done          chan error
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{t: t, in: clientOut, messages: make(chan map[string]json.RawMessage, 100), done: make(chan error, 1)}
+	go func() {
+		c.done <- NewServer(serverIn, serverOut).Serve()
+		serverOut.Close()
+	}()
+	go func() {
+		defer close(c.messages)
+		out := bufio.NewReader(clientIn)
+		for {
+			headers, err := textproto.NewReader(out).ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+			length, _ := strconv.Atoi(headers.Get("Content-Length"))
+			body := make([]byte, length)
+			if _, err := io.ReadFull(out, body); err != nil {
+				return
+			}
+			var msg map[string]json.RawMessage
+			if err := json.Unmarshal(body, &msg); err != nil {
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+	return c
+}
+
+func (c *client) send(msg map[string]interface{}) {
+	msg["jsonrpc"] = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) read() map[string]json.RawMessage {
+	msg, ok := <-c.messages
+	if !ok {
+		c.t.Fatal("the server stopped sending messages")
+	}
+	return msg
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.send(map[string]interface{}{"method": method, "params": params})
+}
+
+//sends a request and decodes the result of the response into result, failing the test if the request failed
+func (c *client) call(method string, params interface{}, result interface{}) {
+	c.nextID++
+	c.send(map[string]interface{}{"id": c.nextID, "method": method, "params": params})
+	for {
+		msg := c.read()
+		if _, isResponse := msg["id"]; !isResponse {
+			var n request
+			n.Method = strings.Trim(string(msg["method"]), `"`)
+			n.Params = msg["params"]
+			c.notifications = append(c.notifications, n)
+			continue
+		}
+		if msg["error"] != nil {
+			c.t.Fatalf("%s failed: %s", method, msg["error"])
+		}
+		if err := json.Unmarshal(msg["result"], result); err != nil {
+			c.t.Fatal(err)
+		}
+		return
+	}
+}
+
+//the diagnostics most recently published for uri, waiting on a request so any pending notifications arrive
+func (c *client) diagnostics(uri string) []diagnostic {
+	c.call("textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": uri}}, new(interface{}))
+	var found []diagnostic
+	for _, n := range c.notifications {
+		var params publishDiagnosticsParams
+		if n.Method == "textDocument/publishDiagnostics" && json.Unmarshal(n.Params, &params) == nil && params.URI == uri {
+			found = params.Diagnostics
+		}
+	}
+	return found
+}
+
+const uri = "file:///project/main.lpy"
+
+const source = `(define square [x] (* x x))
+(define total 0)
+(macro unless "Evaluates body unless c is true." [c body]
+  (do
+    (define helper [y] y)
+    (list 'if c nil body)))
+(square (map square (list 1 2)))`
+
+func at(line int, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     position{Line: line, Character: character},
+	}
+}
+
+func span(startLine, startChar, endLine, endChar int) textRange {
+	return textRange{Start: position{startLine, startChar}, End: position{endLine, endChar}}
+}
+
+func TestServer(t *testing.T) {
+	c := newClient(t)
+	var init initializeResult
+	c.call("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, &init)
+	if !init.Capabilities.HoverProvider || !init.Capabilities.DefinitionProvider || !init.Capabilities.DocumentSymbolProvider {
+		t.Errorf("expected hover, definition and document symbols to be supported, got %+v", init.Capabilities)
+	}
+	c.notify("initialized", map[string]interface{}{})
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "lispy", "version": 1, "text": source},
+	})
+
+	var h hover
+	c.call("textDocument/hover", at(6, 3), &h)
+	if want := "```lispy\n(square x)\n```\nfunction defined on line 1"; h.Contents.Value != want || h.Range != span(6, 1, 6, 7) {
+		t.Errorf("hovering over a user define gave %q at %v, expected %q", h.Contents.Value, h.Range, want)
+	}
+	c.call("textDocument/hover", at(6, 10), &h)
+	if want := "```lispy\n(map arr func)\n```\nfunction\n\nReturns a list of func applied to each element of arr."; h.Contents.Value != want {
+		t.Errorf("hovering over a library function gave %q, expected %q", h.Contents.Value, want)
+	}
+	c.call("textDocument/hover", at(2, 9), &h)
+	if want := "```lispy\n(unless c body)\n```\nmacro defined on line 3\n\nEvaluates body unless c is true."; h.Contents.Value != want {
+		t.Errorf("hovering over a documented define gave %q, expected %q", h.Contents.Value, want)
+	}
+	var nothing *hover
+	c.call("textDocument/hover", at(6, 0), &nothing)
+	if nothing != nil {
+		t.Errorf("expected nothing hovering over a paren, got %+v", nothing)
+	}
+
+	var locations []location
+	c.call("textDocument/definition", at(6, 15), &locations)
+	if want := []location{{URI: uri, Range: span(0, 8, 0, 14)}}; !reflect.DeepEqual(locations, want) {
+		t.Errorf("definition of square gave %+v, expected %+v", locations, want)
+	}
+	c.call("textDocument/definition", at(6, 10), &locations)
+	if len(locations) != 0 {
+		t.Errorf("expected no definition of a builtin, got %+v", locations)
+	}
+
+	var items []completionItem
+	c.call("textDocument/completion", at(6, 3), &items)
+	labels := make([]string, 0)
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	if want := []string{"sqrt", "square"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("completing sq gave %v, expected %v", labels, want)
+	}
+
+	var symbols []documentSymbol
+	c.call("textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": uri}}, &symbols)
+	want := []documentSymbol{
+		{Name: "square", Detail: "(square x)", Kind: symbolFunction, Range: span(0, 0, 0, 27), SelectionRange: span(0, 8, 0, 14)},
+		{Name: "total", Detail: "total", Kind: symbolVariable, Range: span(1, 0, 1, 16), SelectionRange: span(1, 8, 1, 13)},
+		{Name: "unless", Detail: "(unless c body)", Kind: symbolFunction, Range: span(2, 0, 5, 27), SelectionRange: span(2, 7, 2, 13),
+			Children: []documentSymbol{
+				{Name: "helper", Detail: "(helper y)", Kind: symbolFunction, Range: span(4, 4, 4, 25), SelectionRange: span(4, 12, 4, 18)},
+			}},
+	}
+	if !reflect.DeepEqual(symbols, want) {
+		t.Errorf("document symbols were\n%+v\nexpected\n%+v", symbols, want)
+	}
+
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]string{{"text": "(define f [x y] x)\n(f 1 2"}},
+	})
+	diagnostics := c.diagnostics(uri)
+	if want := []diagnostic{{Range: span(1, 0, 1, 6), Severity: severityError, Source: "lispy", Message: "unexpected end of input, missing )"}}; !reflect.DeepEqual(diagnostics, want) {
+		t.Errorf("expected a parse error on the second line, got %+v", diagnostics)
+	}
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 3},
+		"contentChanges": []map[string]string{{"text": "(define f [x y] x)\n  (f 1)\n"}},
+	})
+	diagnostics = c.diagnostics(uri)
+	wantDiagnostics := []diagnostic{
+		{Range: span(0, 0, 0, 18), Severity: severityWarning, Source: "lispy check", Message: "parameter y of f is never used"},
+		{Range: span(1, 2, 1, 7), Severity: severityWarning, Source: "lispy check", Message: "f expects 2 arguments but is called with 1"},
+	}
+	if !reflect.DeepEqual(diagnostics, wantDiagnostics) {
+		t.Errorf("diagnostics were\n%+v\nexpected\n%+v", diagnostics, wantDiagnostics)
+	}
+
+	c.call("shutdown", nil, new(interface{}))
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("expected the server to exit cleanly after shutdown, got %v", err)
+	}
+}
+
+func TestUnsupportedMethod(t *testing.T) {
+	c := newClient(t)
+	c.send(map[string]interface{}{"id": 1, "method": "textDocument/rename", "params": map[string]interface{}{}})
+	msg := c.read()
+	var respErr responseError
+	if err := json.Unmarshal(msg["error"], &respErr); err != nil || respErr.Code != methodNotFoundCode {
+		t.Errorf("expected method not found, got %s", msg["error"])
+	}
+	c.in.Close()
+	if err := <-c.done; err != nil {
+		t.Errorf("expected the server to stop when the client goes away, got %v", err)
+	}
+}